@@ -9,6 +9,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position //节点的词法单元在源码中的位置
 }
 
 type Statement interface {
@@ -33,6 +34,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -55,6 +63,10 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Pos() token.Position {
+	return ls.Token.Pos()
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -107,6 +119,10 @@ func (as *AssignStatement) TokenLiteral() string {
 	return as.Token.Literal
 }
 
+func (as *AssignStatement) Pos() token.Position {
+	return as.Token.Pos()
+}
+
 func (as *AssignStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(as.TokenLiteral() + " ")
@@ -122,6 +138,11 @@ func (as *AssignStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+	//以下由 resolver 填写：Local 为 true 时变量位于向外第 Depth 个局部作用域的第 Slot 个槽位，
+	//否则为按名字查找的全局变量
+	Local bool
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode() {
@@ -132,6 +153,10 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos()
+}
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -149,6 +174,10 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Position {
+	return rs.Token.Pos()
+}
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -172,6 +201,10 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position {
+	return es.Token.Pos()
+}
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -192,6 +225,10 @@ func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
 
+func (il *IntegerLiteral) Pos() token.Position {
+	return il.Token.Pos()
+}
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
@@ -210,6 +247,10 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 
+func (pe *PrefixExpression) Pos() token.Position {
+	return pe.Token.Pos()
+}
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -234,6 +275,10 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *InfixExpression) Pos() token.Position {
+	return ie.Token.Pos()
+}
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -257,6 +302,10 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos()
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -264,6 +313,8 @@ func (b *Boolean) String() string {
 type BlockStatement struct {
 	Token      token.Token //词法单元`{`
 	Statements []Statement
+	Rbrace     token.Token //结尾的`}`
+	NumSlots   int         //块中 let 定义的变量数，由 resolver 填写，为0时块不创建作用域
 }
 
 func (bs *BlockStatement) statementNode() {
@@ -274,6 +325,10 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+func (bs *BlockStatement) Pos() token.Position {
+	return bs.Token.Pos()
+}
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -298,6 +353,10 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Pos() token.Position {
+	return ie.Token.Pos()
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -332,6 +391,10 @@ func (we *WhileExpression) TokenLiteral() string {
 	return we.Token.Literal
 }
 
+func (we *WhileExpression) Pos() token.Position {
+	return we.Token.Pos()
+}
+
 func (we *WhileExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("while")
@@ -357,6 +420,10 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Pos() token.Position {
+	return fl.Token.Pos()
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -374,6 +441,7 @@ func (fl *FunctionLiteral) String() string {
 type PrintlnExpression struct {
 	Token     token.Token //println词法单元
 	Arguments []Expression
+	Rparen    token.Token //结尾的`)`
 }
 
 func (pl *PrintlnExpression) expressionNode() {
@@ -384,6 +452,10 @@ func (pl *PrintlnExpression) TokenLiteral() string {
 	return pl.Token.Literal
 }
 
+func (pl *PrintlnExpression) Pos() token.Position {
+	return pl.Token.Pos()
+}
+
 func (ce *PrintlnExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -401,6 +473,7 @@ type CallExpression struct {
 	Token     token.Token //" `(` 词法单元 "
 	Function  Expression  //标识符或函数字面量
 	Arguments []Expression
+	Rparen    token.Token //结尾的`)`
 }
 
 func (ce *CallExpression) expressionNode() {
@@ -411,6 +484,10 @@ func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+func (ce *CallExpression) Pos() token.Position {
+	return ce.Token.Pos()
+}
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -437,6 +514,129 @@ func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
 
+func (sl *StringLiteral) Pos() token.Position {
+	return sl.Token.Pos()
+}
+
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
+
+type MemberExpression struct {
+	Token    token.Token //`.`词法单元
+	Object   Expression
+	Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {
+
+}
+
+func (me *MemberExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MemberExpression) Pos() token.Position {
+	return me.Token.Pos()
+}
+
+func (me *MemberExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString(me.Object.String())
+	out.WriteString(".")
+	out.WriteString(me.Property.String())
+	return out.String()
+}
+
+// obj.Field = value
+type MemberAssignStatement struct {
+	Token  token.Token //`=`词法单元
+	Target *MemberExpression
+	Value  Expression
+}
+
+func (ms *MemberAssignStatement) statementNode() {
+
+}
+
+func (ms *MemberAssignStatement) TokenLiteral() string {
+	return ms.Token.Literal
+}
+
+func (ms *MemberAssignStatement) Pos() token.Position {
+	return ms.Token.Pos()
+}
+
+func (ms *MemberAssignStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ms.Target.String())
+	out.WriteString(" = ")
+	if ms.Value != nil {
+		out.WriteString(ms.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+type ThrowStatement struct {
+	Token token.Token //throw词法单元
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {
+
+}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) Pos() token.Position {
+	return ts.Token.Pos()
+}
+
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// try { } catch (e) { } finally { }，catch 与 finally 至少有一个
+type TryExpression struct {
+	Token   token.Token //try词法单元
+	Block   *BlockStatement
+	Param   *Identifier //catch 绑定的错误变量
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {
+
+}
+
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) Pos() token.Position {
+	return te.Token.Pos()
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.Param.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+	return out.String()
+}
@@ -0,0 +1,75 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 由 resolver 填写的字段，不属于语法树的结构
+var resolverFields = map[string]bool{"Local": true, "Depth": true, "Slot": true, "NumSlots": true}
+
+// Fprint 以缩进的树形式输出语法树，每行一个节点：字段名、节点类型和节点自身的值(运算符、字面量等)
+func Fprint(w io.Writer, node Node) error {
+	return fprint(w, "", node, 0)
+}
+
+func fprint(w io.Writer, label string, node Node, depth int) error {
+	v := reflect.ValueOf(node)
+	if node == nil || v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	v = reflect.Indirect(v)
+	t := v.Type()
+	var attrs []string
+	type child struct {
+		label string
+		node  Node
+	}
+	var children []child
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if !field.IsExported() || resolverFields[field.Name] {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.String:
+			if t.Name() == "StringLiteral" {
+				attrs = append(attrs, strconv.Quote(value.String()))
+			} else {
+				attrs = append(attrs, value.String())
+			}
+		case reflect.Int64, reflect.Bool:
+			attrs = append(attrs, fmt.Sprint(value.Interface()))
+		case reflect.Interface, reflect.Ptr:
+			if n, ok := value.Interface().(Node); ok {
+				children = append(children, child{field.Name, n})
+			}
+		case reflect.Slice:
+			for j := 0; j < value.Len(); j++ {
+				if n, ok := value.Index(j).Interface().(Node); ok {
+					children = append(children, child{fmt.Sprintf("%s[%d]", field.Name, j), n})
+				}
+			}
+		}
+	}
+	line := strings.Repeat("  ", depth)
+	if label != "" {
+		line += label + ": "
+	}
+	line += t.Name()
+	if len(attrs) > 0 {
+		line += " " + strings.Join(attrs, " ")
+	}
+	if _, err := io.WriteString(w, line+"\n"); err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := fprint(w, c.label, c.node, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package ast_test
+
+import (
+	"TLanguage/ast"
+	"TLanguage/lexer"
+	"TLanguage/parser"
+	"bytes"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer(`let f = fn(x){ if (x > 1) { "big" } else { -x } }; f(2);`)).ParseProgram()
+	var out bytes.Buffer
+	if err := ast.Fprint(&out, program); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Program
+  Statements[0]: LetStatement
+    Name: Identifier f
+    Value: FunctionLiteral
+      Parameters[0]: Identifier x
+      Body: BlockStatement
+        Statements[0]: ExpressionStatement
+          Expression: IfExpression
+            Condition: InfixExpression >
+              Left: Identifier x
+              Right: IntegerLiteral 1
+            Consequence: BlockStatement
+              Statements[0]: ExpressionStatement
+                Expression: StringLiteral "big"
+            Alternative: BlockStatement
+              Statements[0]: ExpressionStatement
+                Expression: PrefixExpression -
+                  Right: Identifier x
+  Statements[1]: ExpressionStatement
+    Expression: CallExpression
+      Function: Identifier f
+      Arguments[0]: IntegerLiteral 2
+`
+	if out.String() != expected {
+		t.Errorf("wrong tree.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
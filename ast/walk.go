@@ -0,0 +1,71 @@
+package ast
+
+import "reflect"
+
+// Walk 按深度优先顺序遍历语法树，fn 返回 false 时不再访问该节点的子节点。
+// 值为nil的节点(包括nil指针)会被跳过
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() || !fn(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Walk(s, fn)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Walk(s, fn)
+		}
+	case *LetStatement:
+		Walk(n.Name, fn)
+		Walk(n.Value, fn)
+	case *AssignStatement:
+		Walk(n.Name, fn)
+		Walk(n.Value, fn)
+	case *MemberAssignStatement:
+		Walk(n.Target, fn)
+		Walk(n.Value, fn)
+	case *ReturnStatement:
+		Walk(n.ReturnValue, fn)
+	case *ThrowStatement:
+		Walk(n.Value, fn)
+	case *ExpressionStatement:
+		Walk(n.Expression, fn)
+	case *PrefixExpression:
+		Walk(n.Right, fn)
+	case *InfixExpression:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *IfExpression:
+		Walk(n.Condition, fn)
+		Walk(n.Consequence, fn)
+		Walk(n.Another, fn)
+		Walk(n.Alternative, fn)
+	case *WhileExpression:
+		Walk(n.Condition, fn)
+		Walk(n.Body, fn)
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Walk(p, fn)
+		}
+		Walk(n.Body, fn)
+	case *CallExpression:
+		Walk(n.Function, fn)
+		for _, a := range n.Arguments {
+			Walk(a, fn)
+		}
+	case *PrintlnExpression:
+		for _, a := range n.Arguments {
+			Walk(a, fn)
+		}
+	case *MemberExpression:
+		Walk(n.Object, fn)
+		Walk(n.Property, fn)
+	case *TryExpression:
+		Walk(n.Block, fn)
+		Walk(n.Param, fn)
+		Walk(n.Catch, fn)
+		Walk(n.Finally, fn)
+	}
+}
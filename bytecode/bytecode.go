@@ -0,0 +1,444 @@
+package bytecode
+
+// 字节码文件格式(整数均为大端序，变长整数使用 encoding/binary 的 varint 编码)：
+//
+//	magic    [4]byte  "TLBC"
+//	version  uint16   FormatVersion
+//	flags    uint16   FlagDebug 表示包含行号表
+//	globals  uvarint 个数，随后为各全局变量名
+//	functions uvarint 个数，随后为各函数，第0个为顶层代码
+//	constants uvarint 个数，随后为各常量(标记字节 + 值)
+//	checksum uint32   之前所有字节的 CRC-32(IEEE)
+//
+// 字符串编码为 uvarint 长度 + 字节。
+
+import (
+	"TLanguage/code"
+	"TLanguage/compiler"
+	"TLanguage/object"
+	"TLanguage/token"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+const Magic = "TLBC"
+
+// 指令集或文件结构变化时递增
+const FormatVersion = 2
+
+const FlagDebug = 1 << 0
+
+// 常量的类型标记
+const (
+	tagInteger  = 'i'
+	tagString   = 's'
+	tagFunction = 'f'
+)
+
+var (
+	ErrNotBytecode = errors.New("not a T bytecode file")
+	ErrVersion     = errors.New("unsupported bytecode version")
+	ErrChecksum    = errors.New("bytecode checksum mismatch")
+	ErrCorrupt     = errors.New("corrupt bytecode")
+)
+
+const headerSize = len(Magic) + 2 + 2
+const checksumSize = 4
+
+// 判断 data 是否以字节码文件头开始
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// 将编译结果编码为字节码文件，debug 为 true 时保留行号表
+func Encode(bc *compiler.Bytecode, debug bool) ([]byte, error) {
+	e := &encoder{debug: debug}
+	e.buf.WriteString(Magic)
+	var flags uint16
+	if debug {
+		flags |= FlagDebug
+	}
+	e.uint16(FormatVersion)
+	e.uint16(flags)
+
+	e.strings(bc.Globals)
+
+	//函数表：顶层代码与常量池中的所有函数
+	functions := []*object.CompiledFunction{{
+		Name:         "<main>",
+		NumLocals:    bc.NumLocals,
+		LocalNames:   bc.LocalNames,
+		Instructions: bc.Instructions,
+		Lines:        bc.Lines,
+	}}
+	index := map[*object.CompiledFunction]int{}
+	for _, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			index[fn] = len(functions)
+			functions = append(functions, fn)
+		}
+	}
+	e.uvarint(uint64(len(functions)))
+	for _, fn := range functions {
+		e.function(fn)
+	}
+
+	e.uvarint(uint64(len(bc.Constants)))
+	for i, c := range bc.Constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			e.buf.WriteByte(tagInteger)
+			e.varint(c.Value)
+		case *object.String:
+			e.buf.WriteByte(tagString)
+			e.string(c.Value)
+		case *object.CompiledFunction:
+			e.buf.WriteByte(tagFunction)
+			e.uvarint(uint64(index[c]))
+		default:
+			return nil, fmt.Errorf("constant %d: cannot encode %s", i, c.Type())
+		}
+	}
+	sum := crc32.ChecksumIEEE(e.buf.Bytes())
+	e.uint32(sum)
+	return e.buf.Bytes(), nil
+}
+
+func WriteFile(path string, bc *compiler.Bytecode, debug bool) error {
+	data, err := Encode(bc, debug)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// 解码并校验字节码文件
+func Decode(data []byte) (*compiler.Bytecode, error) {
+	if !IsBytecode(data) {
+		return nil, ErrNotBytecode
+	}
+	if len(data) < headerSize+checksumSize {
+		return nil, fmt.Errorf("%w: file too short", ErrCorrupt)
+	}
+	if version := binary.BigEndian.Uint16(data[len(Magic):]); version != FormatVersion {
+		return nil, fmt.Errorf("%w: file version %d, supported version %d", ErrVersion, version, FormatVersion)
+	}
+	body := data[:len(data)-checksumSize]
+	if binary.BigEndian.Uint32(data[len(body):]) != crc32.ChecksumIEEE(body) {
+		return nil, ErrChecksum
+	}
+	flags := binary.BigEndian.Uint16(data[len(Magic)+2:])
+	d := &decoder{data: body, pos: headerSize, debug: flags&FlagDebug != 0}
+
+	bc := &compiler.Bytecode{Globals: d.strings()}
+	functions := make([]*object.CompiledFunction, d.count())
+	for i := range functions {
+		functions[i] = d.function()
+	}
+	constants := make([]object.Object, d.count())
+	for i := range constants {
+		switch tag := d.byte(); tag {
+		case tagInteger:
+			constants[i] = &object.Integer{Value: d.varint()}
+		case tagString:
+			constants[i] = &object.String{Value: d.string()}
+		case tagFunction:
+			idx := int(d.uvarint())
+			if idx < 1 || idx >= len(functions) {
+				d.fail("constant %d: function index %d out of range", i, idx)
+				break
+			}
+			constants[i] = functions[idx]
+		default:
+			d.fail("constant %d: unknown tag %q", i, tag)
+		}
+		if d.err != nil {
+			break
+		}
+	}
+	if d.err == nil && d.pos != len(body) {
+		d.fail("%d trailing bytes", len(body)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(functions) == 0 {
+		return nil, fmt.Errorf("%w: missing top-level code", ErrCorrupt)
+	}
+	bc.Instructions = functions[0].Instructions
+	bc.Lines = functions[0].Lines
+	bc.NumLocals, bc.LocalNames = functions[0].NumLocals, functions[0].LocalNames
+	bc.Constants = constants
+	for _, fn := range functions {
+		if err := verify(fn, bc); err != nil {
+			return nil, err
+		}
+	}
+	return bc, nil
+}
+
+func ReadFile(path string) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// 检查指令完整且操作数都指向有效的常量、变量与地址，避免虚拟机执行时越界
+func verify(fn *object.CompiledFunction, bc *compiler.Bytecode) error {
+	ins := fn.Instructions
+	fail := func(offset int, format string, a ...interface{}) error {
+		return fmt.Errorf("%w: %s at %04d: %s", ErrCorrupt, fn.Name, offset, fmt.Sprintf(format, a...))
+	}
+	starts := make(map[int]bool) //每条指令的起始位置
+	jumps := make(map[int]int)   //跳转指令的位置及其目标
+	for i := 0; i < len(ins); {
+		starts[i] = true
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fail(i, "%s", err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fail(i, "truncated %s", def.Name)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant, code.OpClosure, code.OpGetMember, code.OpSetMember:
+			if operands[0] >= len(bc.Constants) {
+				return fail(i, "constant %d out of range", operands[0])
+			}
+			c := bc.Constants[operands[0]]
+			switch code.Opcode(ins[i]) {
+			case code.OpClosure:
+				closure, ok := c.(*object.CompiledFunction)
+				if !ok {
+					return fail(i, "constant %d is not a function", operands[0])
+				}
+				if len(closure.FreeNames) != operands[1] {
+					return fail(i, "function %d captures %d variables, not %d", operands[0], len(closure.FreeNames), operands[1])
+				}
+			case code.OpGetMember, code.OpSetMember:
+				if _, ok := c.(*object.String); !ok {
+					return fail(i, "constant %d is not a string", operands[0])
+				}
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+			jumps[i] = operands[0]
+		case code.OpGetGlobal, code.OpSetGlobal, code.OpDefineGlobal:
+			if operands[0] >= len(bc.Globals) {
+				return fail(i, "global %d out of range", operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpDefineLocal, code.OpCaptureLocal:
+			if operands[0] >= fn.NumLocals || operands[0] >= len(fn.LocalNames) {
+				return fail(i, "local %d out of range", operands[0])
+			}
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			if operands[0] >= len(fn.FreeNames) {
+				return fail(i, "free variable %d out of range", operands[0])
+			}
+		}
+		i += 1 + read
+	}
+	//跳转目标必须是某条指令的开头，不能落在操作数中间
+	for i := 0; i < len(ins); i++ {
+		if target, ok := jumps[i]; ok && !starts[target] {
+			return fail(i, "jump target %d is not an instruction boundary", target)
+		}
+	}
+	if len(ins) == 0 || code.Opcode(ins[len(ins)-1]) != code.OpReturnValue {
+		return fail(len(ins), "missing final OpReturnValue")
+	}
+	return nil
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	debug bool
+}
+
+func (e *encoder) uint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.string(fn.Name)
+	e.uvarint(uint64(fn.NumLocals))
+	e.uvarint(uint64(fn.NumParameters))
+	e.strings(fn.Parameters)
+	e.string(fn.Body)
+	e.strings(fn.LocalNames)
+	e.strings(fn.FreeNames)
+	e.uvarint(uint64(len(fn.Instructions)))
+	e.buf.Write(fn.Instructions)
+	if e.debug {
+		e.uvarint(uint64(len(fn.Lines)))
+		for _, l := range fn.Lines {
+			e.uvarint(uint64(l.Offset))
+			e.uvarint(uint64(l.Pos.Line))
+			e.uvarint(uint64(l.Pos.Column))
+		}
+	}
+}
+
+// 解码器，第一次出错后的读取都返回零值
+type decoder struct {
+	data  []byte
+	pos   int
+	debug bool
+	err   error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.data) {
+		d.fail("unexpected end of file")
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad varint at %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad varint at %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// 读取元素个数，每个元素至少占一个字节，个数不可能超过剩余的字节数
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail("count %d exceeds file size", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	ss := make([]string, d.count())
+	for i := range ss {
+		ss[i] = d.string()
+	}
+	return ss
+}
+
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > 1<<31 {
+		d.fail("value %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{
+		Name:          d.string(),
+		NumLocals:     d.int(),
+		NumParameters: d.int(),
+		Parameters:    d.strings(),
+		Body:          d.string(),
+		LocalNames:    d.strings(),
+		FreeNames:     d.strings(),
+	}
+	fn.Instructions = append(code.Instructions{}, d.bytes()...)
+	if d.debug {
+		fn.Lines = make(code.LineTable, d.count())
+		for i := range fn.Lines {
+			fn.Lines[i] = code.Line{
+				Offset: d.int(),
+				Pos:    token.Position{Line: d.int(), Column: d.int()},
+			}
+		}
+	}
+	if fn.NumParameters > fn.NumLocals {
+		d.fail("function %s has %d parameters but %d locals", fn.Name, fn.NumParameters, fn.NumLocals)
+	}
+	return fn
+}
@@ -0,0 +1,125 @@
+package bytecode
+
+import (
+	"TLanguage/code"
+	"TLanguage/compiler"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"TLanguage/vm"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+const testProgram = `let newAdder = fn(x){ fn(y){ x + y } };
+let addTwo = newAdder(2);
+println("addTwo(3)=", addTwo(3), " ", -7);
+let f = fn(){ throw "boom"; };
+try { f() } catch (e) { println(e.stack) };
+addTwo`
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func run(bc *compiler.Bytecode) (string, object.Object) {
+	var out bytes.Buffer
+	result := vm.New(bc, &out, io.Discard).Run()
+	return out.String(), result
+}
+
+func TestRoundTrip(t *testing.T) {
+	bc := compile(t, testProgram)
+	expectedOutput, expectedResult := run(bc)
+	for _, debug := range []bool{true, false} {
+		data, err := Encode(bc, debug)
+		if err != nil {
+			t.Fatalf("Encode: %s", err)
+		}
+		decoded, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode(debug=%t): %s", debug, err)
+		}
+		output, result := run(decoded)
+		if debug && output != expectedOutput {
+			t.Errorf("output mismatch.\nexpected:\n%s\ngot:\n%s", expectedOutput, output)
+		}
+		if result.Inspect() != expectedResult.Inspect() {
+			t.Errorf("result mismatch. expected %q, got %q", expectedResult.Inspect(), result.Inspect())
+		}
+		if !debug && len(decoded.Lines) != 0 {
+			t.Errorf("line table should be stripped, got %d entries", len(decoded.Lines))
+		}
+		if len(decoded.Constants) != len(bc.Constants) {
+			t.Errorf("wrong number of constants. expected %d, got %d", len(bc.Constants), len(decoded.Constants))
+		}
+	}
+}
+
+// 修改文件内容后重新计算校验和，使错误只能由结构校验发现
+func resum(data []byte) []byte {
+	body := data[:len(data)-checksumSize]
+	binary.BigEndian.PutUint32(data[len(body):], crc32.ChecksumIEEE(body))
+	return data
+}
+
+func TestRejectsBadFiles(t *testing.T) {
+	data, err := Encode(compile(t, testProgram), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := func() []byte { return append([]byte{}, data...) }
+
+	flipped := clone()
+	flipped[len(flipped)/2] ^= 0xff
+
+	version := clone()
+	binary.BigEndian.PutUint16(version[len(Magic):], FormatVersion+1)
+
+	truncated := resum(clone()[:len(data)-10])
+
+	//把顶层代码的第一条指令改为未定义的操作码
+	badOpcode := compile(t, "1")
+	badOpcode.Instructions = append(code.Instructions{255}, badOpcode.Instructions...)
+	badOpcodeData, _ := Encode(badOpcode, false)
+
+	badConstant := compile(t, "1")
+	badConstant.Instructions = append(code.Make(code.OpConstant, 7), badConstant.Instructions...)
+	badConstantData, _ := Encode(badConstant, false)
+
+	//跳转到自身操作数的中间
+	badJump := compile(t, "1")
+	badJump.Instructions = append(code.Make(code.OpJump, 1), badJump.Instructions...)
+	badJumpData, _ := Encode(badJump, false)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"not bytecode", []byte("let a = 1;"), ErrNotBytecode},
+		{"short", []byte(Magic), ErrCorrupt},
+		{"flipped byte", flipped, ErrChecksum},
+		{"version", version, ErrVersion},
+		{"truncated", truncated, ErrCorrupt},
+		{"bad opcode", badOpcodeData, ErrCorrupt},
+		{"bad constant", badConstantData, ErrCorrupt},
+		{"jump into an operand", badJumpData, ErrCorrupt},
+	}
+	for _, tt := range tests {
+		_, err := Decode(tt.data)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
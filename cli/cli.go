@@ -0,0 +1,392 @@
+package cli
+
+import (
+	"TLanguage/bytecode"
+	"TLanguage/compile"
+	"TLanguage/diag"
+	"TLanguage/evaluator"
+	"TLanguage/executor"
+	"TLanguage/format"
+	"TLanguage/repl"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+)
+
+const Version = "0.1.0"
+
+// 退出状态
+const (
+	ExitOK      = 0 //成功
+	ExitRuntime = 1 //程序执行时出现未捕获的错误
+	ExitUsage   = 2 //命令行参数错误
+	ExitSource  = 3 //语法错误、无法解析的标识符等源码错误
+	ExitBuild   = 4 //编译为可执行文件失败
+	ExitIO      = 5 //无法读取或写入文件
+	ExitFormat  = 6 //tl fmt --check 或 --diff 发现需要格式化的文件
+)
+
+const usage = `T programming language
+
+usage: tl <command> [arguments]
+
+commands:
+  run      interpret a program: tl run [-O] [--vm] [--json] file.tl [arguments...]
+           the script reads its arguments with args() and args(i) and ends with exit(n)
+  build    compile a program: tl build [flags] file.tl
+  repl     start an interactive session
+  fmt      format source files in place: tl fmt [--check | --diff] [file.tl...]
+           with no files it formats stdin to stdout
+  check    report parse errors and unresolved identifiers: tl check [--json] file.tl...
+  disasm   print the bytecode of a program: tl disasm file
+  version  print the version
+
+"tl file.tl" is short for "tl run file.tl". Run "tl <command> -h" for the flags of a command.
+
+exit status:
+  0 success, 1 uncaught runtime error, 2 usage error,
+  3 parse or resolve errors, 4 build failure, 5 file error,
+  6 files need formatting (tl fmt --check or --diff);
+  a script that calls exit(n) exits with status n (0..255), also when built
+  with tl build, which passes the binary's arguments to args()
+`
+
+// 一次命令行调用的输入输出
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	json           bool //以 JSON 输出错误
+}
+
+// Main 执行 tl 命令，args 不含程序名，返回退出状态
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	command, rest := args[0], args[1:]
+	switch command {
+	case "run":
+		return c.run(rest)
+	case "build":
+		return c.build(rest)
+	case "repl":
+		return c.repl(rest)
+	case "fmt":
+		return c.fmt(rest)
+	case "check":
+		return c.check(rest)
+	case "disasm":
+		return c.disasm(rest)
+	case "version":
+		fmt.Fprintf(stdout, "tl version %s %s/%s\n", Version, runtime.GOOS, runtime.GOARCH)
+		return ExitOK
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return ExitOK
+	}
+	if strings.HasSuffix(command, ".tl") {
+		return c.run(args)
+	}
+	fmt.Fprintf(stderr, "tl: unknown command %q\nRun \"tl help\" for usage.\n", command)
+	return ExitUsage
+}
+
+// 子命令的参数解析器，出错时输出用法并返回 ExitUsage
+func (c *cli) flags(name, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: %s\n", synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// 解析子命令参数，返回的 ok 为 false 时应以 code 退出
+func (c *cli) parse(flags *flag.FlagSet, args []string) (code int, ok bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK, false
+		}
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// 输出错误并按错误类型返回退出状态，failure 为其他错误的退出状态
+func (c *cli) fail(err error, failure int) int {
+	var sourceErr *compile.SourceError
+	var runtimeErr *compile.RuntimeError
+	var exitErr *compile.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+	case c.json:
+		c.writeJSON(c.stderr, diagnostics("", err))
+	case errors.As(err, &runtimeErr):
+		runtimeErr.Report(c.stderr, diag.Color(c.stderr))
+	case errors.As(err, &sourceErr):
+		sourceErr.Report(c.stderr, diag.Color(c.stderr))
+	default:
+		fmt.Fprintln(c.stderr, err)
+	}
+	return exitStatus(err, failure)
+}
+
+// 按错误类型决定退出状态
+func exitStatus(err error, failure int) int {
+	var sourceErr *compile.SourceError
+	var runtimeErr *compile.RuntimeError
+	var exitErr *compile.ExitError
+	var bytecodeErr *compile.BytecodeError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.Status
+	case errors.As(err, &runtimeErr):
+		return ExitRuntime
+	case errors.As(err, &sourceErr):
+		return ExitSource
+	//字节码文件损坏或被截断与读取失败一样是文件的问题，不是源码错误
+	case errors.As(err, &bytecodeErr), errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission):
+		return ExitIO
+	}
+	return failure
+}
+
+// 以 JSON 输出的诊断信息，附带所在的文件
+type fileDiagnostic struct {
+	File string `json:"file,omitempty"`
+	*diag.Diagnostic
+}
+
+// 将错误转换为诊断信息，path 为错误不含文件名时使用的文件名。
+// 读取文件失败等与源码位置无关的错误只有消息
+func diagnostics(path string, err error) []fileDiagnostic {
+	var sourceErr *compile.SourceError
+	var runtimeErr *compile.RuntimeError
+	var result []fileDiagnostic
+	switch {
+	case errors.As(err, &runtimeErr):
+		result = append(result, fileDiagnostic{runtimeErr.Path, evaluator.Diagnostic(runtimeErr.Err)})
+	case errors.As(err, &sourceErr) && len(sourceErr.Diagnostics) > 0:
+		for _, d := range sourceErr.Diagnostics {
+			result = append(result, fileDiagnostic{sourceErr.Path, d})
+		}
+	case errors.As(err, &sourceErr):
+		for _, msg := range sourceErr.Messages {
+			result = append(result, fileDiagnostic{sourceErr.Path, &diag.Diagnostic{Severity: diag.Error, Message: msg}})
+		}
+	default:
+		result = append(result, fileDiagnostic{path, &diag.Diagnostic{Severity: diag.Error, Message: err.Error()}})
+	}
+	return result
+}
+
+func (c *cli) writeJSON(w io.Writer, diags []fileDiagnostic) {
+	if diags == nil {
+		diags = []fileDiagnostic{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(diags)
+}
+
+func (c *cli) run(args []string) int {
+	flags := c.flags("run", "tl run [-O] [--vm] [--json] file.tl [arguments...]")
+	optimize := flags.Bool("O", false, "fold constants and remove dead code before running")
+	flags.BoolVar(&c.json, "json", false, "report source and uncaught runtime errors on stderr as JSON")
+	useVM := flags.Bool("vm", false, "run on the bytecode virtual machine (always used for "+compile.BytecodeExt+" files)")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+	path := flags.Arg(0)
+	var err error
+	if *useVM || isBytecodeFile(path) {
+		err = compile.Run(path, *optimize, flags.Args()[1:], c.stdout, c.stderr)
+	} else {
+		err = compile.Interpret(path, *optimize, flags.Args()[1:], c.stdout, c.stderr)
+	}
+	if err != nil {
+		return c.fail(err, ExitSource)
+	}
+	return ExitOK
+}
+
+func isBytecodeFile(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytecode.IsBytecode(data)
+}
+
+func (c *cli) build(args []string) int {
+	synopsis := "tl build [-O] [-o output] [--bytecode | --emit-go file.go --goos os --goarch arch --target wasm|wasip1|c --emit-c file.c] file.tl"
+	flags := c.flags("build", synopsis)
+	toBytecode := flags.Bool("bytecode", false, "compile to a "+compile.BytecodeExt+" bytecode file")
+	optimize := flags.Bool("O", false, "fold constants and remove dead code before compiling")
+	var opts executor.Options
+	flags.StringVar(&opts.Output, "o", "", "output file")
+	flags.StringVar(&opts.EmitGo, "emit-go", "", "also write the generated Go source to this file")
+	flags.StringVar(&opts.GOOS, "goos", "", "target operating system")
+	flags.StringVar(&opts.GOARCH, "goarch", "", "target architecture")
+	flags.StringVar(&opts.EmitC, "emit-c", "", "with --target c, also write the generated C source to this file")
+	flags.StringVar(&opts.Target, "target", "", "build a WebAssembly module: "+executor.TargetWasm+" (browser/Node.js) or "+executor.TargetWasip1+" (WASI); "+executor.TargetC+" compiles through C with the system cc")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+	var out string
+	var err error
+	if *toBytecode {
+		out, err = compile.BuildBytecode(flags.Arg(0), *optimize, opts.Output)
+	} else {
+		out, err = compile.Build(flags.Arg(0), *optimize, opts)
+	}
+	if err != nil {
+		return c.fail(err, ExitBuild)
+	}
+	fmt.Fprintln(c.stdout, out)
+	return ExitOK
+}
+
+func (c *cli) repl(args []string) int {
+	flags := c.flags("repl", "tl repl")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	fmt.Fprintf(c.stdout, "Hello %s! This is the T programming language!\n", name)
+	fmt.Fprintln(c.stdout, "Feel free to type in command")
+	repl.Start(c.stdin, c.stdout)
+	return ExitOK
+}
+
+// 格式化源文件，默认直接改写文件。--check 只列出需要格式化的文件，--diff 输出需要的修改，
+// 两者都不改写文件，有文件需要格式化时以 ExitFormat 退出。没有给出文件时格式化标准输入并输出到 stdout
+func (c *cli) fmt(args []string) int {
+	flags := c.flags("fmt", "tl fmt [--check | --diff] [file.tl...]")
+	check := flags.Bool("check", false, "list the files whose formatting differs, do not rewrite them")
+	diff := flags.Bool("diff", false, "print the changes as a unified diff, do not rewrite the files")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return c.formatFile("", *check, *diff)
+	}
+	status := ExitOK
+	for _, path := range flags.Args() {
+		if code := c.formatFile(path, *check, *diff); code > status {
+			status = code
+		}
+	}
+	return status
+}
+
+// 格式化一个文件，path 为空时格式化标准输入
+func (c *cli) formatFile(path string, check, diff bool) int {
+	name := path
+	var content []byte
+	var err error
+	if path == "" {
+		name = "<stdin>"
+		content, err = io.ReadAll(c.stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return c.fail(err, ExitIO)
+	}
+	src := string(content)
+	out, err := compile.Format(name, src)
+	if err != nil {
+		return c.fail(err, ExitSource)
+	}
+	switch {
+	case diff:
+		if out != src {
+			fmt.Fprint(c.stdout, format.Diff("a/"+name, "b/"+name, src, out))
+			return ExitFormat
+		}
+	case check:
+		if out != src {
+			fmt.Fprintln(c.stdout, name)
+			return ExitFormat
+		}
+	case path == "":
+		fmt.Fprint(c.stdout, out)
+	case out != src:
+		if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+			return c.fail(err, ExitIO)
+		}
+	}
+	return ExitOK
+}
+
+// 检查每个文件，全部通过时不输出任何内容。--json 时向 stdout 输出全部文件的诊断信息组成的数组
+func (c *cli) check(args []string) int {
+	flags := c.flags("check", "tl check [--json] file.tl...")
+	flags.BoolVar(&c.json, "json", false, "write the diagnostics to stdout as a JSON array")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	status := ExitOK
+	var all []fileDiagnostic
+	for _, path := range flags.Args() {
+		err := compile.Check(path)
+		if err == nil {
+			continue
+		}
+		code := exitStatus(err, ExitSource)
+		if c.json {
+			all = append(all, diagnostics(path, err)...)
+		} else {
+			c.fail(err, ExitSource)
+		}
+		if code > status {
+			status = code
+		}
+	}
+	if c.json {
+		c.writeJSON(c.stdout, all)
+	}
+	return status
+}
+
+func (c *cli) disasm(args []string) int {
+	flags := c.flags("disasm", "tl disasm file")
+	if code, ok := c.parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+	if err := compile.Disassemble(flags.Arg(0), c.stdout); err != nil {
+		return c.fail(err, ExitSource)
+	}
+	return ExitOK
+}
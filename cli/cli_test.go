@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 在临时目录中写入源文件，返回文件路径
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommands(t *testing.T) {
+	ok := writeFile(t, "ok.tl", `let sq = fn(x){ x * x }; println("sq=", sq(4));`)
+	failing := writeFile(t, "fail.tl", "let f = fn(){ 1 + true };\nf();")
+	syntax := writeFile(t, "syntax.tl", "let = 1;")
+	unresolved := writeFile(t, "unresolved.tl", "println(missing);")
+	missing := filepath.Join(t.TempDir(), "missing.tl")
+	script := writeFile(t, "script.tl", `println(args(), " ", args(0), "|", args(1)); exit(3); println("unreachable");`)
+	exitInTry := writeFile(t, "try.tl", `try { exit(4) } catch (e) { println("caught") } finally { println("finally") }`)
+	exitZero := writeFile(t, "zero.tl", `println("done"); exit(0); println("unreachable");`)
+	badArgs := writeFile(t, "bad.tl", `println(args(5));`)
+	badStatus := writeFile(t, "status.tl", `exit(256);`)
+	typo := writeFile(t, "typo.tl", "let count = 1;\nprintln(cuont);")
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string //stdout 应包含的内容
+		stderr string //stderr 应包含的内容
+	}{
+		{nil, ExitUsage, "", "usage: tl <command>"},
+		{[]string{"help"}, ExitOK, "usage: tl <command>", ""},
+		{[]string{"version"}, ExitOK, "tl version " + Version, ""},
+		{[]string{"frobnicate"}, ExitUsage, "", `unknown command "frobnicate"`},
+		{[]string{"run", ok}, ExitOK, "sq=16\n", ""},
+		{[]string{ok}, ExitOK, "sq=16\n", ""},
+		{[]string{"run", "--vm", ok}, ExitOK, "sq=16\n", ""},
+		{[]string{"run", failing}, ExitRuntime, "", "type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", syntax}, ExitSource, "", "parse errors"},
+		{[]string{"run", missing}, ExitIO, "", "no such file"},
+		{[]string{"run"}, ExitUsage, "", "usage: tl run"},
+		{[]string{"run", "--bogus", ok}, ExitUsage, "", "flag provided but not defined"},
+		{[]string{"run", "-h"}, ExitOK, "", "usage: tl run"},
+		{[]string{"run", script, "a", "b c"}, 3, "2 a|b c\n", ""},
+		{[]string{script, "-x", "y"}, 3, "2 -x|y\n", ""},
+		{[]string{"run", exitInTry}, 4, "", ""},
+		{[]string{"run", exitZero}, ExitOK, "done\n", ""},
+		{[]string{"run", badArgs}, ExitRuntime, "", "args: index out of range: 5 with 0 arguments"},
+		{[]string{"run", badStatus}, ExitRuntime, "", "exit: status 256 out of range 0..255"},
+		{[]string{"run", "--vm", script, "a", "b c"}, 3, "2 a|b c\n", ""},
+		{[]string{"run", "--vm", exitInTry}, 4, "", ""},
+		{[]string{"run", "--vm", badStatus}, ExitRuntime, "", "exit: status 256 out of range 0..255"},
+		{[]string{"check", ok}, ExitOK, "", ""},
+		{[]string{"check", script}, ExitOK, "", ""},
+		{[]string{"check", ok, unresolved}, ExitSource, "", "identifier not found: missing"},
+		{[]string{"check", syntax}, ExitSource, "", "parse errors"},
+		{[]string{"check", syntax}, ExitSource, "", "1 | let = 1;\n  |     ^\n"},
+		{[]string{"check", typo}, ExitSource, "", "typo.tl:2:9\n  |\n2 | println(cuont);\n  |         ^^^^^\n  = help: did you mean `count`?"},
+		{[]string{"run", failing}, ExitRuntime, "", "    let f = fn(){ 1 + true };\n"},
+		{[]string{"build", "--target", "bogus", ok}, ExitBuild, "", "unknown target"},
+		{[]string{"disasm", ok}, ExitOK, "== <main>", ""},
+		{[]string{"repl"}, ExitOK, "This is the T programming language", ""},
+		{[]string{"fmt", "--check", ok}, ExitFormat, "ok.tl\n", ""},
+		{[]string{"fmt", "--diff", ok}, ExitFormat, "+let sq = fn(x) {\n+    x * x;\n+};\n", ""},
+		{[]string{"fmt", "--check", syntax}, ExitSource, "", "1 | let = 1;"},
+		{[]string{"fmt", missing}, ExitIO, "", "no such file"},
+		{[]string{"fmt"}, ExitOK, "", ""},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := Main(tt.args, strings.NewReader(""), &stdout, &stderr)
+		if code != tt.code {
+			t.Errorf("%v: expected exit status %d, got %d\nstderr: %s", tt.args, tt.code, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tt.stdout) {
+			t.Errorf("%v: stdout should contain %q, got %q", tt.args, tt.stdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%v: stderr should contain %q, got %q", tt.args, tt.stderr, stderr.String())
+		}
+	}
+}
+
+// 默认直接改写文件，已格式化的文件通过 --check；没有文件时格式化标准输入
+func TestFmt(t *testing.T) {
+	path := writeFile(t, "a.tl", "// 注释\nlet a=1\nprintln(a)")
+	expected := "// 注释\nlet a = 1;\nprintln(a);\n"
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"fmt", path}, nil, &stdout, &stderr); code != ExitOK || stdout.Len() != 0 {
+		t.Fatalf("expected exit status %d and no output, got %d\nstdout: %s\nstderr: %s", ExitOK, code, stdout.String(), stderr.String())
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != expected {
+		t.Errorf("expected the file to be rewritten as %q, got %q", expected, content)
+	}
+	for _, flag := range []string{"--check", "--diff"} {
+		if code := Main([]string{"fmt", flag, path}, nil, &stdout, &stderr); code != ExitOK || stdout.Len() != 0 {
+			t.Errorf("%s: a formatted file should pass, got %d\nstdout: %s", flag, code, stdout.String())
+		}
+	}
+	stdout.Reset()
+	if code := Main([]string{"fmt"}, strings.NewReader("if(a){b}"), &stdout, &stderr); code != ExitOK || stdout.String() != "if (a) {\n    b;\n}\n" {
+		t.Errorf("unexpected result for stdin: %d %q", code, stdout.String())
+	}
+}
+
+// Go 编译器报错时返回 ExitBuild
+func TestBuildFailure(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	path := writeFile(t, "ok.tl", `println(1);`)
+	var stdout, stderr bytes.Buffer
+	code := Main([]string{"build", "--goos", "nosuchos", "-o", filepath.Join(t.TempDir(), "prog"), path}, nil, &stdout, &stderr)
+	if code != ExitBuild {
+		t.Errorf("expected exit status %d, got %d\nstderr: %s", ExitBuild, code, stderr.String())
+	}
+}
+
+// exit、args 在字节码、Go 和 C 后端上与解释器的行为相同
+func TestScriptBuiltinsWhenBuilt(t *testing.T) {
+	script := writeFile(t, "script.tl", `println(args(), " ", args(0)); try { exit(3) } finally { println("finally") }`)
+	dir := t.TempDir()
+	bytecodeFile := filepath.Join(dir, "script.tlbc")
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"build", "--bytecode", "-o", bytecodeFile, script}, nil, &stdout, &stderr); code != ExitOK {
+		t.Fatalf("build --bytecode: exit status %d\nstderr: %s", code, stderr.String())
+	}
+	stdout.Reset()
+	if code := Main([]string{"run", bytecodeFile, "x", "y"}, nil, &stdout, &stderr); code != 3 || stdout.String() != "2 x\n" {
+		t.Errorf("bytecode: expected exit status 3 and %q, got %d %q\nstderr: %s", "2 x\n", code, stdout.String(), stderr.String())
+	}
+	for _, target := range []struct{ tool, flag string }{{"go", ""}, {"cc", "c"}} {
+		if _, err := exec.LookPath(target.tool); err != nil {
+			continue
+		}
+		binary := filepath.Join(dir, "script-"+target.tool)
+		args := []string{"build", "-o", binary, script}
+		if target.flag != "" {
+			args = append([]string{"build", "--target", target.flag}, args[1:]...)
+		}
+		if code := Main(args, nil, &stdout, &stderr); code != ExitOK {
+			t.Fatalf("%v: exit status %d\nstderr: %s", args, code, stderr.String())
+		}
+		out, err := exec.Command(binary, "x", "y").Output()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 || string(out) != "2 x\n" {
+			t.Errorf("%s: expected exit status 3 and %q, got %v %q", target.tool, "2 x\n", err, out)
+		}
+	}
+}
+
+// 损坏或被截断的字节码文件是文件的问题，退出状态与读取失败相同
+func TestCorruptBytecode(t *testing.T) {
+	script := writeFile(t, "script.tl", `println("ok");`)
+	dir := t.TempDir()
+	bytecodeFile := filepath.Join(dir, "script.tlbc")
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"build", "--bytecode", "-o", bytecodeFile, script}, nil, &stdout, &stderr); code != ExitOK {
+		t.Fatalf("build --bytecode: exit status %d\nstderr: %s", code, stderr.String())
+	}
+	data, err := os.ReadFile(bytecodeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	for name, content := range map[string][]byte{"truncated.tlbc": data[:len(data)-3], "corrupt.tlbc": corrupt} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, command := range []string{"run", "disasm"} {
+			stderr.Reset()
+			if code := Main([]string{command, path}, nil, &stdout, &stderr); code != ExitIO || !strings.Contains(stderr.String(), name) {
+				t.Errorf("%s %s: expected exit status %d, got %d\nstderr: %s", command, name, ExitIO, code, stderr.String())
+			}
+		}
+	}
+}
+
+// -O 只对本次调用有效，不影响之后的调用
+func TestOptimizeFlagPerCall(t *testing.T) {
+	path := writeFile(t, "dead.tl", "if (false) { println(missing) }\nprintln(\"ok\");")
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"run", "-O", path}, ExitOK},
+		{[]string{"run", path}, ExitSource},
+		{[]string{"run", "-O", "--vm", path}, ExitOK},
+		{[]string{"run", "--vm", path}, ExitSource},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := Main(tt.args, nil, &stdout, &stderr); code != tt.code {
+			t.Errorf("%v: expected exit status %d, got %d\nstderr: %s", tt.args, tt.code, code, stderr.String())
+		}
+	}
+}
+
+// 输出随执行写出，在出错之前已经写入
+func TestRunStreamsOutput(t *testing.T) {
+	path := writeFile(t, "stream.tl", "println(\"before\");\nlet f = fn(){ throw \"boom\" };\nf();")
+	var out bytes.Buffer
+	code := Main([]string{"run", path}, nil, &out, &out)
+	if code != ExitRuntime {
+		t.Errorf("expected exit status %d, got %d", ExitRuntime, code)
+	}
+	if !strings.HasPrefix(out.String(), "before\nTraceback") || !strings.HasSuffix(out.String(), "Error: boom\n") {
+		t.Errorf("wrong output order: %q", out.String())
+	}
+}
+
+func TestJSONDiagnostics(t *testing.T) {
+	ok := writeFile(t, "ok.tl", `println(1);`)
+	typo := writeFile(t, "typo.tl", "let count = 1;\nprintln(cuont);")
+	syntax := writeFile(t, "syntax.tl", "let x = (1;")
+	var stdout, stderr bytes.Buffer
+	code := Main([]string{"check", "--json", ok, typo, syntax}, nil, &stdout, &stderr)
+	if code != ExitSource {
+		t.Errorf("expected exit status %d, got %d", ExitSource, code)
+	}
+	var diags []struct {
+		File     string
+		Code     string
+		Message  string
+		Expected string
+		Span     struct{ Start, End struct{ Line, Column int } }
+		Fixes    []struct{ Replacement string }
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &diags); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout.String(), err)
+	}
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %s", stdout.String())
+	}
+	d := diags[0]
+	if d.File != typo || d.Code != "E0201" || d.Span.Start.Line != 2 || d.Span.Start.Column != 9 || d.Span.End.Column != 14 ||
+		len(d.Fixes) != 1 || d.Fixes[0].Replacement != "count" {
+		t.Errorf("wrong diagnostic for the unresolved identifier: %+v", d)
+	}
+	if d := diags[1]; d.File != syntax || d.Code != "E0101" || d.Expected != ")" {
+		t.Errorf("wrong diagnostic for the parse error: %+v", d)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+
+	stdout.Reset()
+	if code := Main([]string{"check", "--json", ok}, nil, &stdout, &stderr); code != ExitOK || strings.TrimSpace(stdout.String()) != "[]" {
+		t.Errorf("clean files should give an empty array, got %d %q", code, stdout.String())
+	}
+
+	failing := writeFile(t, "fail.tl", `throw "boom";`)
+	stdout.Reset()
+	if code := Main([]string{"run", "--json", failing}, nil, &stdout, &stderr); code != ExitRuntime {
+		t.Errorf("expected exit status %d, got %d", ExitRuntime, code)
+	}
+	if !strings.Contains(stderr.String(), `"code": "E0302"`) || strings.Contains(stderr.String(), "Traceback") {
+		t.Errorf("runtime error should be reported as JSON, got %q", stderr.String())
+	}
+}
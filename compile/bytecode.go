@@ -0,0 +1,132 @@
+package compile
+
+import (
+	"TLanguage/bytecode"
+	"TLanguage/compiler"
+	"TLanguage/diag"
+	"TLanguage/disasm"
+	"TLanguage/evaluator"
+	"TLanguage/object"
+	"TLanguage/vm"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 字节码文件的扩展名
+const BytecodeExt = ".tlbc"
+
+// 解析并编译源文件，optimize 为 true 时先优化语法树
+func compileFile(path string, optimize bool) (*compiler.Bytecode, error) {
+	program, _, err := parseFile(path, optimize, isScriptBuiltin)
+	if err != nil {
+		return nil, err
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, &SourceError{Path: path, What: "compile errors", Messages: []string{err.Error()}}
+	}
+	return comp.Bytecode(), nil
+}
+
+// 将源文件编译为字节码文件，返回输出文件名；out 为空时输出到源文件旁的同名文件
+func BuildBytecode(path string, optimize bool, out string) (string, error) {
+	bc, err := compileFile(path, optimize)
+	if err != nil {
+		return "", err
+	}
+	if out == "" {
+		out = strings.TrimSuffix(path, filepath.Ext(path)) + BytecodeExt
+	}
+	if err := bytecode.WriteFile(out, bc, true); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// 字节码文件损坏、被截断或版本不符，无法加载
+type BytecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *BytecodeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *BytecodeError) Unwrap() error {
+	return e.Err
+}
+
+// 加载源文件或字节码文件，optimize 只对源文件有效
+func Load(path string, optimize bool) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytecode.IsBytecode(data) {
+		bc, err := bytecode.Decode(data)
+		if err != nil {
+			return nil, &BytecodeError{Path: path, Err: err}
+		}
+		return bc, nil
+	}
+	return compileFile(path, optimize)
+}
+
+// 程序执行时未被捕获的错误
+type RuntimeError struct {
+	Err    *object.Error
+	Path   string
+	Source string //源文件的内容，字节码文件为空
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Kind + ": " + e.Err.Message
+}
+
+// Report 输出附带源码行的调用栈
+func (e *RuntimeError) Report(w io.Writer, color bool) {
+	_, _ = io.WriteString(w, diag.Traceback(e.Path, e.Source, e.Err, color))
+}
+
+// 用虚拟机执行源文件或字节码文件，args 为脚本的命令行参数。
+// 程序中未定义的 exit、args 使用与解释器相同的内置函数
+func Run(path string, optimize bool, args []string, stdout, stderr io.Writer) error {
+	bc, err := Load(path, optimize)
+	if err != nil {
+		return err
+	}
+	globals := make([]object.Object, len(bc.Globals))
+	builtins := evaluator.ScriptBuiltins(args)
+	for i, name := range bc.Globals {
+		if builtin, ok := builtins[name]; ok {
+			globals[i] = builtin
+		}
+	}
+	result := vm.NewWithGlobalsStore(bc, globals, stdout, stderr).Run()
+	if _, ok := result.(*object.Error); !ok {
+		return nil
+	}
+	//源文件附带出错的源码行，字节码文件没有源码
+	src := ""
+	if data, readErr := os.ReadFile(path); readErr == nil && !bytecode.IsBytecode(data) {
+		src = string(data)
+	}
+	return scriptError(result, path, src)
+}
+
+// 反汇编源文件或字节码文件，源文件的每组指令前附带对应的源码行
+func Disassemble(path string, w io.Writer) error {
+	bc, err := Load(path, false)
+	if err != nil {
+		return err
+	}
+	source := ""
+	if data, err := os.ReadFile(path); err == nil && !bytecode.IsBytecode(data) {
+		source = string(data)
+	}
+	return disasm.Fprint(w, bc, source)
+}
@@ -1,35 +1,189 @@
 package compile
 
 import (
+	"TLanguage/ast"
+	"TLanguage/diag"
 	"TLanguage/evaluator"
 	"TLanguage/executor"
+	"TLanguage/format"
 	"TLanguage/lexer"
 	"TLanguage/object"
+	"TLanguage/optimizer"
 	"TLanguage/parser"
+	"TLanguage/resolver"
+	"TLanguage/token"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
-func Start(in io.Reader, out io.Writer, path string) {
+// 源文件中的语法错误、无法解析的标识符等，程序尚未开始执行
+type SourceError struct {
+	Path        string
+	What        string //错误的类别，如 "parse errors"
+	Messages    []string
+	Source      string             //源文件的内容
+	Diagnostics []*diag.Diagnostic //带有位置的错误，没有时只输出 Messages
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %s:\n\t%s", e.Path, e.What, strings.Join(e.Messages, "\n\t"))
+}
+
+// Report 以指向源码的形式输出每个错误，最后输出错误的类别
+func (e *SourceError) Report(w io.Writer, color bool) {
+	if len(e.Diagnostics) == 0 {
+		_, _ = fmt.Fprintln(w, e.Error())
+		return
+	}
+	for _, d := range e.Diagnostics {
+		_ = diag.Fprint(w, e.Path, e.Source, d, color)
+	}
+	_, _ = fmt.Fprintf(w, "%s: %s\n", e.Path, e.What)
+}
+
+// 读取并解析源文件，报告语法错误与无法解析的标识符，isGlobal 识别宿主提供的全局变量，可为 nil。
+// optimize 为 true 时优化语法树。同时返回源文件的内容，用于输出运行时错误
+func parseFile(path string, optimize bool, isGlobal func(name string) bool) (*ast.Program, string, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, "", err
 	}
-	env := object.NewEnvironment()
-	prog := string(content)
-	l := lexer.NewLexer(prog)
-	p := parser.NewParser(l)
+	src := string(content)
+	p := parser.NewParser(lexer.NewLexer(src))
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		printParseErrors(out, p.Errors())
-		return
+		return nil, src, sourceError(path, "parse errors", src, p.Errors())
+	}
+	if optimize {
+		optimizer.Optimize(program)
+	}
+	if diags := resolve(program, isGlobal); len(diags) != 0 {
+		return nil, src, sourceError(path, "unresolved identifiers", src, diags)
+	}
+	return program, src, nil
+}
+
+func sourceError(path, what, src string, diags []*diag.Diagnostic) *SourceError {
+	var msgs []string
+	for _, d := range diags {
+		msgs = append(msgs, d.Error())
+	}
+	return &SourceError{Path: path, What: what, Messages: msgs, Source: src, Diagnostics: diags}
+}
+
+// 脚本调用 exit(n) 结束，n 不为0
+type ExitError struct {
+	Status int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// 是否为 tl run 提供的内置函数
+func isScriptBuiltin(name string) bool {
+	_, ok := evaluator.ScriptBuiltins(nil)[name]
+	return ok
+}
+
+// 检查源文件能否通过解析，不执行
+func Check(path string) error {
+	_, _, err := parseFile(path, false, isScriptBuiltin)
+	return err
+}
+
+// 格式化源码，有语法错误时返回 *SourceError，path 只用于错误信息
+func Format(path, src string) (string, error) {
+	out, err := format.Source(src)
+	var formatErr *format.Error
+	if errors.As(err, &formatErr) {
+		return "", sourceError(path, "parse errors", src, formatErr.Diagnostics)
+	}
+	return out, err
+}
+
+// 用解释器直接执行源文件，println 的输出直接写入 stdout，未捕获的错误以 *RuntimeError 返回。
+// optimize 为 true 时先优化语法树，args 为脚本的命令行参数
+func Interpret(path string, optimize bool, args []string, stdout, stderr io.Writer) error {
+	program, src, err := parseFile(path, optimize, isScriptBuiltin)
+	if err != nil {
+		return err
+	}
+	env := object.NewEnvironment()
+	for name, builtin := range evaluator.ScriptBuiltins(args) {
+		env.Set(name, builtin)
 	}
-	evaluator.Eval(program, env)
-	executor.Exec(evaluator.OUT, path)
+	result := evaluator.New(stdout, stderr).Eval(program, env)
+	return scriptError(result, path, src)
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		_, _ = io.WriteString(out, "\t"+msg+"\n")
+// 将脚本执行的结果转换为错误：exit(n) 为 *ExitError(n 为0时没有错误)，其他未捕获的错误为 *RuntimeError
+func scriptError(result object.Object, path, src string) error {
+	err, ok := result.(*object.Error)
+	switch {
+	case !ok:
+		return nil
+	case err.Kind == object.EXIT_ERROR && err.Status == 0:
+		return nil
+	case err.Kind == object.EXIT_ERROR:
+		return &ExitError{Status: err.Status}
 	}
+	return &RuntimeError{Err: err, Path: path, Source: src}
+}
+
+// 将源文件编译为可执行文件，返回可执行文件的路径。optimize 为 true 时先优化语法树
+func Build(path string, optimize bool, opts executor.Options) (string, error) {
+	program, _, err := parseFile(path, optimize, isScriptBuiltin)
+	if err != nil {
+		return "", err
+	}
+	return executor.Build(program, path, opts)
+}
+
+// 在执行前找出无法解析的标识符，并从关键字、内置函数和程序中定义的名字里给出拼写建议
+func resolve(program *ast.Program, isGlobal func(name string) bool) []*diag.Diagnostic {
+	errs := resolver.Resolve(program, isGlobal)
+	if len(errs) == 0 {
+		return nil
+	}
+	candidates := append(token.Keywords(), declaredNames(program)...)
+	for name := range evaluator.ScriptBuiltins(nil) {
+		if isGlobal != nil && isGlobal(name) {
+			candidates = append(candidates, name)
+		}
+	}
+	var diags []*diag.Diagnostic
+	for _, err := range errs {
+		span := diag.TokenSpan(token.Token{Type: token.IDENT, Literal: err.Name, Line: err.Pos.Line, Column: err.Pos.Column})
+		d := diag.Errorf(diag.CodeUnresolved, span, "identifier not found: %s", err.Name)
+		d.SuggestName(err.Name, span, candidates)
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// 程序中用 let、函数参数和 catch 定义的全部名字
+func declaredNames(program *ast.Program) []string {
+	var names []string
+	ast.Walk(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.LetStatement:
+			if n.Name != nil {
+				names = append(names, n.Name.Value)
+			}
+		case *ast.FunctionLiteral:
+			for _, param := range n.Parameters {
+				names = append(names, param.Value)
+			}
+		case *ast.TryExpression:
+			if n.Param != nil {
+				names = append(names, n.Param.Value)
+			}
+		}
+		return true
+	})
+	return names
 }
@@ -0,0 +1,542 @@
+package compiler
+
+import (
+	"TLanguage/ast"
+	"TLanguage/code"
+	"TLanguage/object"
+	"TLanguage/token"
+	"fmt"
+)
+
+// 编译结果：顶层代码的指令与常量池
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Lines        code.LineTable //顶层指令的行号表
+	Globals      []string       //按槽位排列的全局变量名
+	NumLocals    int            //顶层块中的局部变量数
+	LocalNames   []string       //按槽位排列的顶层局部变量名
+}
+
+// 一个函数(或顶层代码)的编译状态
+type CompilationScope struct {
+	instructions code.Instructions
+	lines        code.LineTable
+	tries        []tryContext //正在编译的 try 语句，由外到内排列
+}
+
+// return 离开 try 语句前需要注销异常处理地址并执行 finally
+type tryContext struct {
+	handler bool //异常处理地址是否仍然登记着
+	finally *ast.BlockStatement
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+	scopes      []CompilationScope
+	scopeIndex  int
+	pos         token.Position //正在编译的节点的位置
+	err         error          //操作数越界等在生成指令时发现的错误
+}
+
+func New() *Compiler {
+	return &Compiler{
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+// 沿用之前的符号表与常量池，用于REPL中逐行编译
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.Line > 0 {
+		prev := c.pos
+		c.pos = pos
+		defer func() {
+			c.pos = prev
+		}()
+	}
+	switch node := node.(type) {
+	case *ast.Program:
+		//顶层代码与函数体一样以最后一个表达式语句的值作为结果
+		if err := c.compileStatements(node.Statements, true); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+		return c.err
+	case *ast.BlockStatement:
+		return c.compileBlock(node, true)
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.LetStatement:
+		//先定义再编译函数字面量，使函数体中可以递归引用自身
+		if fl, ok := node.Value.(*ast.FunctionLiteral); ok {
+			symbol := c.symbolTable.Define(node.Name.Value)
+			if err := c.compileFunction(fl, node.Name.Value); err != nil {
+				return err
+			}
+			if symbol.Scope == GlobalScope {
+				c.defineSymbol(symbol)
+			} else {
+				//函数体可能已经捕获了这个变量，需要写入其单元
+				c.storeSymbol(symbol)
+			}
+			return nil
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.defineSymbol(c.symbolTable.Define(node.Name.Value))
+	case *ast.AssignStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok {
+			symbol = c.symbolTable.reserve(node.Name.Value)
+		}
+		c.storeSymbol(symbol)
+	case *ast.MemberAssignStatement:
+		if err := c.Compile(node.Target.Object); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, c.addConstant(&object.String{Value: node.Target.Property.Value}))
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.unwindTries(); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			symbol = c.symbolTable.reserve(node.Value)
+		}
+		c.loadSymbol(symbol)
+	case *ast.IfExpression:
+		return c.compileIf(node)
+	case *ast.WhileExpression:
+		start := len(c.currentInstructions())
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.compileBlock(node.Body, false); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+		c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+		c.emit(code.OpNull)
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		//与解释器一致，调用位置为被调函数表达式的位置
+		prev := c.pos
+		c.pos = node.Function.Pos()
+		c.emit(code.OpCall, len(node.Arguments))
+		c.pos = prev
+	case *ast.PrintlnExpression:
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPrintln, len(node.Arguments))
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpGetMember, c.addConstant(&object.String{Value: node.Property.Value}))
+	case *ast.TryExpression:
+		return c.compileTry(node)
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+	return nil
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+// 编译语句序列。keepValue 为 true 时在栈上留下最后一个表达式语句的值，
+// 最后一条不是表达式语句时留下 null
+func (c *Compiler) compileStatements(stmts []ast.Statement, keepValue bool) error {
+	for i, s := range stmts {
+		if es, ok := s.(*ast.ExpressionStatement); ok && keepValue && i == len(stmts)-1 {
+			return c.Compile(es.Expression)
+		}
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+	if keepValue {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// 块有自己的作用域，与解释器中每个块一个环境一致
+func (c *Compiler) compileBlock(block *ast.BlockStatement, keepValue bool) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	err := c.compileStatements(block.Statements, keepValue)
+	c.symbolTable = c.symbolTable.Outer
+	return err
+}
+
+func (c *Compiler) compileIf(ie *ast.IfExpression) error {
+	if err := c.Compile(ie.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+	if err := c.compileBlock(ie.Consequence, true); err != nil {
+		return err
+	}
+	jump := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	switch {
+	case ie.Another != nil:
+		if err := c.Compile(ie.Another); err != nil {
+			return err
+		}
+	case ie.Alternative != nil:
+		if err := c.compileBlock(ie.Alternative, true); err != nil {
+			return err
+		}
+	default:
+		c.emit(code.OpNull)
+	}
+	c.changeOperand(jump, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileFunction(fl *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+	params := make([]string, len(fl.Parameters))
+	for i, p := range fl.Parameters {
+		c.symbolTable.Define(p.Value)
+		params[i] = p.String()
+	}
+	if err := c.compileBlock(fl.Body, true); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	localNames := c.symbolTable.Names()
+	instructions, lines := c.leaveScope()
+	markTailCalls(instructions)
+
+	freeNames := make([]string, len(freeSymbols))
+	for i, s := range freeSymbols {
+		freeNames[i] = s.Name
+		if s.Scope == LocalScope {
+			c.emit(code.OpCaptureLocal, s.Index)
+		} else {
+			c.emit(code.OpCaptureFree, s.Index)
+		}
+	}
+	fn := &object.CompiledFunction{
+		Name:          name,
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(fl.Parameters),
+		Parameters:    params,
+		Body:          fl.Body.String(),
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+		Lines:         lines,
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(freeSymbols))
+	return nil
+}
+
+// try 块正常结束时注销异常处理地址并执行 finally；出错时虚拟机跳到处理地址，
+// 错误位于栈顶，绑定到 catch 的变量或在执行 finally 后重新抛出
+func (c *Compiler) compileTry(te *ast.TryExpression) error {
+	try := c.emit(code.OpTry, 9999)
+	c.pushTry(te.Finally)
+	if err := c.compileBlock(te.Block, true); err != nil {
+		return err
+	}
+	c.popTry()
+	c.emit(code.OpEndTry)
+	if err := c.compileFinally(te.Finally); err != nil {
+		return err
+	}
+	jumps := []int{c.emit(code.OpJump, 9999)}
+	c.changeOperand(try, len(c.currentInstructions()))
+
+	if te.Catch == nil {
+		if err := c.compileFinally(te.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	} else {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		c.defineSymbol(c.symbolTable.Define(te.Param.Value))
+		if te.Finally == nil {
+			if err := c.compileBlock(te.Catch, true); err != nil {
+				return err
+			}
+		} else {
+			//catch 中的错误也要先执行 finally
+			tryCatch := c.emit(code.OpTry, 9999)
+			c.pushTry(te.Finally)
+			if err := c.compileBlock(te.Catch, true); err != nil {
+				return err
+			}
+			c.popTry()
+			c.emit(code.OpEndTry)
+			if err := c.compileFinally(te.Finally); err != nil {
+				return err
+			}
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(tryCatch, len(c.currentInstructions()))
+			if err := c.compileFinally(te.Finally); err != nil {
+				return err
+			}
+			c.emit(code.OpThrow)
+		}
+		c.symbolTable = c.symbolTable.Outer
+	}
+	for _, jump := range jumps {
+		c.changeOperand(jump, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.compileBlock(finally, false)
+}
+
+func (c *Compiler) pushTry(finally *ast.BlockStatement) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, tryContext{handler: true, finally: finally})
+}
+
+func (c *Compiler) popTry() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+}
+
+// return 前由内到外离开当前函数中所有的 try 语句
+func (c *Compiler) unwindTries() error {
+	scope := &c.scopes[c.scopeIndex]
+	tries := scope.tries
+	defer func() {
+		c.scopes[c.scopeIndex].tries = tries
+	}()
+	for i := len(tries) - 1; i >= 0; i-- {
+		if tries[i].handler {
+			c.emit(code.OpEndTry)
+		}
+		//finally 中的 return 只需离开更外层的 try 语句
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if err := c.compileFinally(tries[i].finally); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// 赋值给已有的变量
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// 定义新的变量
+func (c *Compiler) defineSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpDefineGlobal, s.Index)
+	} else {
+		c.emit(code.OpDefineLocal, s.Index)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+	ins := code.Make(op, operands...)
+	pos := len(c.currentInstructions())
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = append(scope.instructions, ins...)
+	if n := len(scope.lines); n == 0 || scope.lines[n-1].Pos != c.pos {
+		scope.lines = append(scope.lines, code.Line{Offset: pos, Pos: c.pos})
+	}
+	return pos
+}
+
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	def, _ := code.Lookup(byte(op))
+	for i, o := range operands {
+		if o < 0 || o >= 1<<(8*def.OperandWidths[i]) {
+			if c.err == nil {
+				c.err = fmt.Errorf("%d:%d: operand %d of %s out of range", c.pos.Line, c.pos.Column, o, def.Name)
+			}
+		}
+	}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// 修改跳转等指令的操作数
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operand)
+	copy(c.currentInstructions()[opPos:], code.Make(op, operand))
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, code.LineTable) {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope.instructions, scope.lines
+}
+
+// 调用之后直接返回(或跳转后返回)的调用处于尾位置，改为复用栈帧的 OpTailCall
+func markTailCalls(ins code.Instructions) {
+	for i := 0; i < len(ins); {
+		def, _ := code.Lookup(ins[i])
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+		if code.Opcode(ins[i]) == code.OpCall && returnsAt(ins, next) {
+			ins[i] = byte(code.OpTailCall)
+		}
+		i = next
+	}
+}
+
+func returnsAt(ins code.Instructions, pos int) bool {
+	for pos < len(ins) {
+		switch code.Opcode(ins[pos]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			target := int(code.ReadUint16(ins[pos+1:]))
+			if target <= pos {
+				return false
+			}
+			pos = target
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	numLocals, localNames := c.symbolTable.MainLocals()
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Globals:      c.symbolTable.Names(),
+		NumLocals:    numLocals,
+		LocalNames:   localNames,
+	}
+}
@@ -0,0 +1,132 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// 符号表。每个函数一张函数符号表，函数内的每个块一张块符号表，
+// 块中定义的变量占用所属函数的局部槽位。顶层代码直接定义的变量是全局变量，
+// 顶层块中定义的变量是顶层代码的局部变量
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	block          bool
+	FreeSymbols    []Symbol //函数捕获的外层变量，按 OpGetFree 的下标排列
+	names          []string //按槽位排列的变量名
+	numLocals      int      //最外层符号表：顶层块中的局部变量数
+	localNames     []string //最外层符号表：按槽位排列的顶层局部变量名
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+// 函数的符号表
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// 块的符号表
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// 块所属的函数符号表，全局作用域下为最外层符号表
+func (s *SymbolTable) function() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
+}
+
+func (s *SymbolTable) Define(name string) Symbol {
+	fn := s.function()
+	scope := LocalScope
+	if fn.Outer == nil && s.block {
+		//与函数中的块一样，每次执行到 let 都定义新的变量，之前创建的闭包仍引用原来的变量
+		symbol := Symbol{Name: name, Scope: LocalScope, Index: fn.numLocals}
+		fn.numLocals++
+		fn.localNames = append(fn.localNames, name)
+		s.store[name] = symbol
+		return symbol
+	}
+	if fn.Outer == nil {
+		scope = GlobalScope
+		//最外层重复定义的变量沿用原来的槽位，与解释器在同一环境中覆盖变量一致
+		if sym, ok := s.store[name]; ok && sym.Scope == GlobalScope {
+			return sym
+		}
+	}
+	symbol := Symbol{Name: name, Scope: scope, Index: fn.numDefinitions}
+	fn.numDefinitions++
+	fn.names = append(fn.names, name)
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if sym, ok := s.store[name]; ok {
+		return sym, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+	sym, ok := s.Outer.Resolve(name)
+	if !ok || sym.Scope == GlobalScope || s.block {
+		return sym, ok
+	}
+	//外层函数的变量成为本函数的自由变量
+	return s.defineFree(sym), true
+}
+
+// 为尚未定义的名字预留全局槽位，运行时读取未赋值的槽位时报告找不到标识符。
+// 这样全局函数可以引用在其后定义的全局变量
+func (s *SymbolTable) reserve(name string) Symbol {
+	root := s
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	return root.Define(name)
+}
+
+// 函数(或全局)已分配的槽位数
+func (s *SymbolTable) NumDefinitions() int {
+	return s.function().numDefinitions
+}
+
+// 按槽位排列的变量名
+func (s *SymbolTable) Names() []string {
+	return s.function().names
+}
+
+// 顶层代码的局部变量数和按槽位排列的变量名
+func (s *SymbolTable) MainLocals() (int, []string) {
+	root := s
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	return root.numLocals, root.localNames
+}
@@ -0,0 +1,301 @@
+// Package diag 以 rustc 的风格输出指向源码位置的错误信息：
+// 文件名与行列号、出错的源码行、位置下方的 ^ 标记，以及附加的说明和建议
+package diag
+
+import (
+	"TLanguage/object"
+	"TLanguage/token"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 严重程度
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// 错误码，按来源分段：E00xx 词法分析，E01xx 语法分析，E02xx 静态检查，E03xx 运行时
+const (
+	CodeIllegalCharacter   = "E0001" //无法识别的字符
+	CodeUnterminatedString = "E0002" //字符串缺少结尾的引号
+	CodeUnexpectedToken    = "E0101" //遇到的 token 不是期望的 token
+	CodeExpectedExpression = "E0102" //此处应为表达式
+	CodeInvalidInteger     = "E0103" //整数字面量超出范围
+	CodeUnclosedBlock      = "E0104" //代码块缺少 }
+	CodeMissingHandler     = "E0105" //try 之后没有 catch 或 finally
+	CodeUnresolved         = "E0201" //无法解析的标识符
+	CodeRuntime            = "E0301" //未捕获的运行时错误
+	CodeThrown             = "E0302" //throw 抛出且未被捕获的错误
+	CodeLimit              = "E0303" //超出步数、时间、调用深度或内存限制，或被取消
+)
+
+// Span 是源码中的一段，包含 Start 不包含 End
+type Span struct {
+	Start token.Position `json:"start"`
+	End   token.Position `json:"end"`
+}
+
+// TokenSpan 返回 tok 在源码中所占的范围
+func TokenSpan(tok token.Token) Span {
+	text := tok.Literal
+	switch tok.Type {
+	case token.STRING:
+		text = `"` + text + `"`
+	case token.EOF:
+		text = " "
+	}
+	end := tok.Pos()
+	for _, c := range text {
+		if c == '\n' {
+			end.Line++
+			end.Column = 1
+		} else {
+			end.Column += utf8.RuneLen(c)
+		}
+	}
+	return Span{Start: tok.Pos(), End: end}
+}
+
+// Fix 是建议的修改：用 Replacement 替换 Span 覆盖的源码，Span 为空时表示插入
+type Fix struct {
+	Message     string `json:"message"`
+	Span        Span   `json:"span"`
+	Replacement string `json:"replacement"`
+}
+
+// Diagnostic 描述源码中某一段的问题
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Span     Span     `json:"span"`
+	Message  string   `json:"message"`
+	Expected string   `json:"expected,omitempty"` //期望的 token
+	Found    string   `json:"found,omitempty"`    //实际遇到的 token
+	Notes    []string `json:"notes,omitempty"`    //补充说明，输出为 note:
+	Hints    []string `json:"hints,omitempty"`    //修改建议，输出为 help:
+	Fixes    []Fix    `json:"fixes,omitempty"`    //可以自动应用的修改
+}
+
+// Errorf 创建一条错误
+func Errorf(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%d:%d: %s", d.Span.Start.Line, d.Span.Start.Column, d.Message)
+}
+
+// SuggestName 在 candidates 中找到与 span 处的 name 相近的词时，添加建议和对应的修改
+func (d *Diagnostic) SuggestName(name string, span Span, candidates []string) {
+	if s := Suggest(name, candidates); s != "" {
+		d.Hints = append(d.Hints, fmt.Sprintf("did you mean `%s`?", s))
+		d.Fixes = append(d.Fixes, Fix{Message: fmt.Sprintf("replace `%s` with `%s`", name, s), Span: span, Replacement: s})
+	}
+}
+
+// 在单行中标记的字节数，跨行时标记到行尾
+func (d *Diagnostic) length(line string) int {
+	if d.Span.End.Line == d.Span.Start.Line {
+		return d.Span.End.Column - d.Span.Start.Column
+	}
+	return len(line) - d.Span.Start.Column + 1
+}
+
+// 终端颜色
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	red    = "\x1b[1;31m"
+	yellow = "\x1b[1;33m"
+	blue   = "\x1b[1;34m"
+	cyan   = "\x1b[1;36m"
+)
+
+// Color 判断写入 w 的内容是否应当着色：w 为终端且未设置 NO_COLOR
+func Color(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type printer struct {
+	color bool
+}
+
+func (p *printer) paint(style, s string) string {
+	if !p.color {
+		return s
+	}
+	return style + s + reset
+}
+
+// Fprint 输出一条诊断信息，file 为源文件名，src 为源文件的内容：
+//
+//	error[E0101]: expected next token to be ), but got ;
+//	 --> main.tl:3:15
+//	  |
+//	3 | let x = add(1, 2;
+//	  |                 ^
+//	  = help: ...
+func Fprint(w io.Writer, file, src string, d *Diagnostic, color bool) error {
+	p := &printer{color: color}
+	var out strings.Builder
+	style, severity := red, d.Severity
+	if severity == "" {
+		severity = Error
+	}
+	if severity == Warning {
+		style = yellow
+	}
+	header := string(severity)
+	if d.Code != "" {
+		header += "[" + d.Code + "]"
+	}
+	out.WriteString(p.paint(style, header) + p.paint(bold, ": "+d.Message) + "\n")
+	pos := d.Span.Start
+	line, ok := SourceLine(src, pos.Line)
+	gutter := strings.Repeat(" ", len(fmt.Sprint(pos.Line)))
+	fmt.Fprintf(&out, "%s%s %s:%d:%d\n", gutter, p.paint(blue, "-->"), file, pos.Line, pos.Column)
+	if ok {
+		bar := p.paint(blue, "|")
+		fmt.Fprintf(&out, "%s %s\n", gutter, bar)
+		fmt.Fprintf(&out, "%s %s %s\n", p.paint(blue, fmt.Sprint(pos.Line)), bar, line)
+		fmt.Fprintf(&out, "%s %s %s\n", gutter, bar, p.paint(style, Underline(line, pos.Column, d.length(line))))
+	}
+	for _, note := range d.Notes {
+		fmt.Fprintf(&out, "%s %s %s %s\n", gutter, p.paint(blue, "="), p.paint(bold, "note:"), note)
+	}
+	for _, hint := range d.Hints {
+		fmt.Fprintf(&out, "%s %s %s %s\n", gutter, p.paint(blue, "="), p.paint(cyan, "help:"), hint)
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// SourceLine 返回源码的第 n 行(从1开始)
+func SourceLine(src string, n int) (string, bool) {
+	lines := strings.Split(src, "\n")
+	if n < 1 || n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// Underline 返回放在 line 下方、从第 column 个字节开始覆盖 length 个字节的 ^ 标记。
+// 制表符原样保留，中日韩等宽字符占两列，使标记与上方的字符对齐
+func Underline(line string, column, length int) string {
+	if length < 1 {
+		length = 1
+	}
+	var b strings.Builder
+	i := 0
+	for i < column-1 && i < len(line) {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case r == '\t':
+			b.WriteByte('\t')
+		case isWide(r):
+			b.WriteString("  ")
+		default:
+			b.WriteByte(' ')
+		}
+		i += size
+	}
+	//标记按显示宽度计算，超出行尾的部分(如文件结尾)至少保留一个 ^
+	width := 0
+	for j := i; j < i+length && j < len(line); {
+		r, size := utf8.DecodeRuneInString(line[j:])
+		if isWide(r) {
+			width += 2
+		} else {
+			width++
+		}
+		j += size
+	}
+	if width == 0 {
+		width = 1
+	}
+	b.WriteString(strings.Repeat("^", width))
+	return b.String()
+}
+
+func isWide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+		r >= 0xFF01 && r <= 0xFF60 || r >= 0x3000 && r <= 0x303F
+}
+
+// Traceback 与 object.Error.Traceback 相同，但在调用栈的每一层下附带源码行和 ^ 标记，
+// 连续重复的帧同样合并
+func Traceback(file, src string, err *object.Error, color bool) string {
+	p := &printer{color: color}
+	var out strings.Builder
+	if len(err.Stack) > 0 {
+		out.WriteString("Traceback (most recent call last):\n")
+	}
+	for _, entry := range object.CollapseStack(err.Stack) {
+		if entry.Repeat > 0 {
+			out.WriteString("  " + entry.String() + "\n")
+			continue
+		}
+		f := entry.Frame
+		fmt.Fprintf(&out, "  File %q, line %d, column %d, in %s\n", file, f.Line, f.Column, f.Function)
+		if line, ok := SourceLine(src, f.Line); ok && strings.TrimSpace(line) != "" {
+			out.WriteString("    " + line + "\n")
+			out.WriteString("    " + p.paint(red, Underline(line, f.Column, 1)) + "\n")
+		}
+	}
+	out.WriteString(p.paint(red, err.Kind) + ": " + err.Message + "\n")
+	return out.String()
+}
+
+// Suggest 在 candidates 中找出与 name 最接近的词，差别过大或没有时返回空字符串
+func Suggest(name string, candidates []string) string {
+	//允许的编辑距离随长度增加，最多为2，过短的名字不给建议
+	best, bestDistance := "", min((utf8.RuneCountInString(name)+1)/3, 2)+1
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		if d := distance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// 两个词之间的编辑距离，相邻字符交换算一次修改
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	//d[i][j] 为 s[:i] 与 t[:j] 的距离
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
@@ -0,0 +1,146 @@
+package diag
+
+import (
+	"TLanguage/object"
+	"TLanguage/token"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	src := "let x = 1;\nwhlie (x < 3) { x = x + 1 }\n"
+	d := &Diagnostic{
+		Code:    CodeExpectedExpression,
+		Span:    Span{Start: token.Position{Line: 2, Column: 15}, End: token.Position{Line: 2, Column: 16}},
+		Message: "no prefix parse function for { found",
+		Notes:   []string{"statements cannot start with {"},
+		Hints:   []string{"did you mean `while`?"},
+	}
+	var out bytes.Buffer
+	if err := Fprint(&out, "main.tl", src, d, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := "error[E0102]: no prefix parse function for { found\n" +
+		" --> main.tl:2:15\n" +
+		"  |\n" +
+		"2 | whlie (x < 3) { x = x + 1 }\n" +
+		"  |               ^\n" +
+		"  = note: statements cannot start with {\n" +
+		"  = help: did you mean `while`?\n"
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	_ = Fprint(&out, "main.tl", src, d, true)
+	if !strings.Contains(out.String(), "^"+reset) {
+		t.Errorf("colored output should paint the caret, got %q", out.String())
+	}
+}
+
+func TestUnderline(t *testing.T) {
+	tests := []struct {
+		line           string
+		column, length int
+		expected       string
+	}{
+		{"let abc = 1;", 5, 3, "    ^^^"},
+		{"\tx + y", 2, 1, "\t^"},
+		{`let s = "中文"; s + ;`, 21, 1, strings.Repeat(" ", 18) + "^"},
+		{`"中文"`, 2, 6, " ^^^^"},
+		{"abc", 4, 1, "   ^"},
+		{"abc", 1, 0, "^"},
+	}
+	for _, tt := range tests {
+		if got := Underline(tt.line, tt.column, tt.length); got != tt.expected {
+			t.Errorf("Underline(%q, %d, %d) = %q, want %q", tt.line, tt.column, tt.length, got, tt.expected)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	keywords := []string{"fn", "let", "if", "else", "return", "while", "println"}
+	tests := []struct {
+		name, expected string
+	}{
+		{"lte", "let"},
+		{"retrun", "return"},
+		{"whlie", "while"},
+		{"prinltn", "println"},
+		{"x", ""},
+		{"let", ""},
+		{"banana", ""},
+	}
+	for _, tt := range tests {
+		if got := Suggest(tt.name, keywords); got != tt.expected {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTokenSpan(t *testing.T) {
+	tests := []struct {
+		tok      token.Token
+		expected Span
+	}{
+		{token.Token{Type: token.IDENT, Literal: "abc", Line: 1, Column: 5}, Span{token.Position{Line: 1, Column: 5}, token.Position{Line: 1, Column: 8}}},
+		{token.Token{Type: token.STRING, Literal: "中", Line: 2, Column: 1}, Span{token.Position{Line: 2, Column: 1}, token.Position{Line: 2, Column: 6}}},
+		{token.Token{Type: token.STRING, Literal: "a\nbc", Line: 1, Column: 3}, Span{token.Position{Line: 1, Column: 3}, token.Position{Line: 2, Column: 4}}},
+		{token.Token{Type: token.EOF, Line: 3, Column: 1}, Span{token.Position{Line: 3, Column: 1}, token.Position{Line: 3, Column: 2}}},
+	}
+	for _, tt := range tests {
+		if got := TokenSpan(tt.tok); got != tt.expected {
+			t.Errorf("TokenSpan(%+v) = %+v, want %+v", tt.tok, got, tt.expected)
+		}
+	}
+}
+
+func TestSuggestName(t *testing.T) {
+	span := Span{token.Position{Line: 1, Column: 1}, token.Position{Line: 1, Column: 4}}
+	d := Errorf(CodeUnresolved, span, "identifier not found: %s", "lte")
+	d.SuggestName("lte", span, []string{"let", "fn"})
+	if len(d.Hints) != 1 || d.Hints[0] != "did you mean `let`?" {
+		t.Errorf("wrong hints %q", d.Hints)
+	}
+	if len(d.Fixes) != 1 || d.Fixes[0].Replacement != "let" || d.Fixes[0].Span != span {
+		t.Errorf("wrong fixes %+v", d.Fixes)
+	}
+	d = Errorf(CodeUnresolved, span, "identifier not found: %s", "banana")
+	d.SuggestName("banana", span, []string{"let", "fn"})
+	if d.Hints != nil || d.Fixes != nil {
+		t.Errorf("unexpected suggestion %q", d.Hints)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	d := Errorf(CodeUnexpectedToken, Span{token.Position{Line: 1, Column: 9}, token.Position{Line: 1, Column: 10}}, "expected next token to be ), but got ;")
+	d.Expected, d.Found = ")", ";"
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"severity":"error","code":"E0101","span":{"start":{"line":1,"column":9},"end":{"line":1,"column":10}},` +
+		`"message":"expected next token to be ), but got ;","expected":")","found":";"}`
+	if string(data) != expected {
+		t.Errorf("wrong JSON.\nexpected: %s\ngot:      %s", expected, data)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	err := object.NewError("boom")
+	err.Stack = []object.Frame{{Function: "<main>", Line: 2, Column: 1}, {Function: "f", Line: 1, Column: 16}}
+	src := "let f = fn() { throw \"boom\" };\nf();"
+	expected := "Traceback (most recent call last):\n" +
+		"  File \"t.tl\", line 2, column 1, in <main>\n" +
+		"    f();\n" +
+		"    ^\n" +
+		"  File \"t.tl\", line 1, column 16, in f\n" +
+		"    let f = fn() { throw \"boom\" };\n" +
+		"                   ^\n" +
+		"RuntimeError: boom\n"
+	if got := Traceback("t.tl", src, err, false); got != expected {
+		t.Errorf("wrong traceback.\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+}
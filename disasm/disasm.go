@@ -0,0 +1,140 @@
+package disasm
+
+import (
+	"TLanguage/code"
+	"TLanguage/compiler"
+	"TLanguage/object"
+	"TLanguage/token"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 反汇编器：把字节码转换为带注释的可读文本
+type disassembler struct {
+	out       *bufio.Writer
+	constants []object.Object
+	globals   []string
+	source    []string //源码按行切分，字节码文件没有源码时为空
+}
+
+// 将 bc 反汇编后写入 w；source 为生成 bc 的源码，未知时传空串
+// 先输出顶层代码，再按常量池顺序逐个输出其中的函数
+func Fprint(w io.Writer, bc *compiler.Bytecode, source string) error {
+	d := &disassembler{
+		out:       bufio.NewWriter(w),
+		constants: bc.Constants,
+		globals:   bc.Globals,
+	}
+	if source != "" {
+		d.source = strings.Split(source, "\n")
+	}
+	locals := ""
+	if bc.NumLocals > 0 {
+		locals = fmt.Sprintf(" locals=%d", bc.NumLocals)
+	}
+	fmt.Fprintf(d.out, "== <main> globals=%d%s constants=%d ==\n", len(bc.Globals), locals, len(bc.Constants))
+	d.instructions(bc.Instructions, bc.Lines, &object.CompiledFunction{Name: "<main>", LocalNames: bc.LocalNames})
+	for i, constant := range bc.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(d.out, "\n== %s [constant %d] params=%d locals=%d free=%d ==\n",
+			functionName(fn), i, fn.NumParameters, fn.NumLocals, len(fn.FreeNames))
+		d.instructions(fn.Instructions, fn.Lines, fn)
+	}
+	return d.out.Flush()
+}
+
+// 逐条输出指令，源码行变化时先输出该行源码
+func (d *disassembler) instructions(ins code.Instructions, lines code.LineTable, fn *object.CompiledFunction) {
+	lastLine := -1
+	for i := 0; i < len(ins); {
+		pos := lines.Lookup(i)
+		if pos.Line != lastLine && pos.Line > 0 && pos.Line <= len(d.source) {
+			fmt.Fprintf(d.out, "%16s| %s\n", strconv.Itoa(pos.Line)+" ", strings.TrimSpace(d.source[pos.Line-1]))
+		}
+		lastLine = pos.Line
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(d.out, "%04d %9s  ERROR: %s\n", i, position(pos), err)
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		text := def.Name
+		for _, o := range operands {
+			text += " " + strconv.Itoa(o)
+		}
+		if comment := d.comment(code.Opcode(ins[i]), operands, fn); comment != "" {
+			text = fmt.Sprintf("%-24s ; %s", text, comment)
+		}
+		fmt.Fprintf(d.out, "%04d %9s  %s\n", i, position(pos), text)
+		i += 1 + read
+	}
+}
+
+// 解析操作数的含义：常量的值、变量名或跳转目标
+func (d *disassembler) comment(op code.Opcode, operands []int, fn *object.CompiledFunction) string {
+	switch op {
+	case code.OpConstant, code.OpGetMember, code.OpSetMember, code.OpClosure:
+		if operands[0] < len(d.constants) {
+			return d.constant(d.constants[operands[0]])
+		}
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpDefineGlobal:
+		return name(d.globals, operands[0])
+	case code.OpGetLocal, code.OpSetLocal, code.OpDefineLocal, code.OpCaptureLocal:
+		if fn != nil {
+			return name(fn.LocalNames, operands[0])
+		}
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if fn != nil {
+			return name(fn.FreeNames, operands[0])
+		}
+	case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+		return fmt.Sprintf("-> %04d", operands[0])
+	}
+	return ""
+}
+
+func (d *disassembler) constant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		for i, c := range d.constants {
+			if c == obj {
+				return fmt.Sprintf("%s [constant %d]", functionName(obj), i)
+			}
+		}
+		return functionName(obj)
+	}
+	return obj.Inspect()
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return "fn " + name + "(" + strings.Join(fn.Parameters, ", ") + ")"
+}
+
+func name(names []string, index int) string {
+	if index < len(names) && names[index] != "" {
+		return names[index]
+	}
+	return ""
+}
+
+// 没有调试信息时位置为空
+func position(pos token.Position) string {
+	if pos.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
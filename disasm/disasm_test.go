@@ -0,0 +1,55 @@
+package disasm
+
+import (
+	"TLanguage/compiler"
+	"TLanguage/lexer"
+	"TLanguage/parser"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	input := `let greeting = "hi";
+let make = fn(n){
+    fn(){ n + 1 }
+};
+println(greeting, make(2)());`
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var out bytes.Buffer
+	if err := Fprint(&out, comp.Bytecode(), input); err != nil {
+		t.Fatal(err)
+	}
+	listing := out.String()
+
+	expected := []string{
+		"== <main> globals=2 constants=",
+		"              1 | let greeting = \"hi\";",
+		"0000      1:16  OpConstant 0             ; \"hi\"",
+		"0003       1:1  OpDefineGlobal 0         ; greeting",
+		"; fn make(n) [constant 3]",
+		"== fn <anonymous>() [constant 2] params=0 locals=0 free=1 ==",
+		"OpGetFree 0              ; n",
+		"== fn make(n) [constant 3] params=1 locals=1 free=0 ==",
+		"OpCaptureLocal 0         ; n",
+		"              5 | println(greeting, make(2)());",
+	}
+	for _, want := range expected {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing does not contain %q. got:\n%s", want, listing)
+		}
+	}
+
+	// 字节码文件没有源码，只输出位置
+	out.Reset()
+	if err := Fprint(&out, comp.Bytecode(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "| ") {
+		t.Errorf("listing without source should not contain source lines. got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,43 @@
+package evaluator
+
+import (
+	"TLanguage/object"
+)
+
+// 运行脚本时提供的内置函数，argv 为脚本的命令行参数：
+// exit(n) 以 n 为退出状态结束程序(省略时为0，须在 0..255 之间)，不会被 catch 捕获，也不执行 finally；
+// args() 返回参数个数，args(i) 返回第 i 个参数(从0开始)
+func ScriptBuiltins(argv []string) map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"exit": {Name: "exit", Fn: func(args ...object.Object) object.Object {
+			status := int64(0)
+			if len(args) > 0 {
+				n, ok := args[0].(*object.Integer)
+				if !ok {
+					return object.NewError("exit: argument must be INTEGER, got %s", args[0].Type())
+				}
+				status = n.Value
+			}
+			//操作系统只保留退出状态的低8位，超出范围的值会变成别的状态
+			if status < 0 || status > 255 {
+				return object.NewError("exit: status %d out of range 0..255", status)
+			}
+			err := object.NewErrorKind(object.EXIT_ERROR, "exit status %d", status)
+			err.Status = int(status)
+			return err
+		}},
+		"args": {Name: "args", Fn: func(args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return &object.Integer{Value: int64(len(argv))}
+			}
+			i, ok := args[0].(*object.Integer)
+			if !ok {
+				return object.NewError("args: index must be INTEGER, got %s", args[0].Type())
+			}
+			if i.Value < 0 || i.Value >= int64(len(argv)) {
+				return object.NewError("args: index out of range: %d with %d arguments", i.Value, len(argv))
+			}
+			return &object.String{Value: argv[i.Value]}
+		}},
+	}
+}
@@ -0,0 +1,31 @@
+package evaluator
+
+import (
+	"TLanguage/diag"
+	"TLanguage/object"
+	"TLanguage/token"
+	"fmt"
+)
+
+// Diagnostic 将未捕获的错误转换为诊断信息：位置为出错处，外层的调用栈作为说明
+func Diagnostic(err *object.Error) *diag.Diagnostic {
+	code := diag.CodeRuntime
+	switch err.Kind {
+	case object.THROWN_ERROR:
+		code = diag.CodeThrown
+	case object.STEP_LIMIT_ERROR, object.TIMEOUT_ERROR, object.CANCELED_ERROR, object.DEPTH_LIMIT_ERROR, object.ALLOC_LIMIT_ERROR:
+		code = diag.CodeLimit
+	}
+	var span diag.Span
+	if n := len(err.Stack); n > 0 {
+		f := err.Stack[n-1]
+		span.Start = token.Position{Line: f.Line, Column: f.Column}
+		span.End = token.Position{Line: f.Line, Column: f.Column + 1}
+	}
+	d := diag.Errorf(code, span, "%s: %s", err.Kind, err.Message)
+	for i := len(err.Stack) - 1; i >= 0; i-- {
+		f := err.Stack[i]
+		d.Notes = append(d.Notes, fmt.Sprintf("in %s at %d:%d", f.Function, f.Line, f.Column))
+	}
+	return d
+}
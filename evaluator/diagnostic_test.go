@@ -0,0 +1,36 @@
+package evaluator
+
+import (
+	"TLanguage/diag"
+	"TLanguage/object"
+	"fmt"
+	"testing"
+)
+
+func TestDiagnostic(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    string
+		message string
+		line    int
+		column  int
+		notes   []string
+	}{
+		{"let f = fn() { 1 + true };\nf();", diag.CodeRuntime, "RuntimeError: type mismatch: INTEGER + BOOLEAN", 1, 18, []string{"in f at 1:18", "in <main> at 2:1"}},
+		{`throw "boom";`, diag.CodeThrown, "Error: boom", 1, 1, []string{"in <main> at 1:1"}},
+	}
+	for _, tt := range tests {
+		err, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Fatalf("%q: expected an error", tt.input)
+		}
+		d := Diagnostic(err)
+		if d.Code != tt.code || d.Message != tt.message || d.Span.Start.Line != tt.line || d.Span.Start.Column != tt.column {
+			t.Errorf("%q: expected %s %d:%d %q, got %s %d:%d %q", tt.input, tt.code, tt.line, tt.column, tt.message,
+				d.Code, d.Span.Start.Line, d.Span.Start.Column, d.Message)
+		}
+		if fmt.Sprint(d.Notes) != fmt.Sprint(tt.notes) {
+			t.Errorf("%q: expected notes %q, got %q", tt.input, tt.notes, d.Notes)
+		}
+	}
+}
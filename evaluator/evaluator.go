@@ -3,6 +3,10 @@ package evaluator
 import (
 	"TLanguage/ast"
 	"TLanguage/object"
+	"TLanguage/token"
+	"context"
+	"io"
+	"os"
 	"strings"
 )
 
@@ -12,9 +16,53 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
-var (
-	OUT []string
-)
+// 解释器：保存一次求值所需的状态，不同的解释器可以在不同的goroutine中同时使用
+type Interpreter struct {
+	Stdout io.Writer //println 的输出
+	Stderr io.Writer
+	Limits Limits //执行限制
+
+	ctx   context.Context
+	steps int64  //已求值的节点数
+	depth int    //当前函数调用深度
+	calls []call //当前调用栈，用于生成错误的调用栈信息
+	tries int    //当前函数内正在执行的 try 语句数，此时不做尾调用
+}
+
+// 一次函数调用：被调函数名及调用位置
+type call struct {
+	name string
+	site token.Position
+}
+
+func New(stdout, stderr io.Writer) *Interpreter {
+	return &Interpreter{Stdout: stdout, Stderr: stderr}
+}
+
+// 使用输出到标准输出的解释器求值
+func Eval(node ast.Node, env *object.Enviroment) object.Object {
+	return New(os.Stdout, os.Stderr).Eval(node, env)
+}
+
+func (in *Interpreter) Eval(node ast.Node, env *object.Enviroment) object.Object {
+	return in.EvalContext(context.Background(), node, env)
+}
+
+// 在ctx下求值，ctx被取消或触发 in.Limits 中的任一限制时停止求值并返回错误对象。
+// node 应来自 parser 解析的程序，其中的局部变量已经分配了槽位；求值不修改语法树
+func (in *Interpreter) EvalContext(ctx context.Context, node ast.Node, env *object.Enviroment) object.Object {
+	if in.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.Limits.Timeout)
+		defer cancel()
+	}
+	in.ctx = ctx
+	in.steps = 0
+	in.depth = 0
+	in.calls = in.calls[:0]
+	in.tries = 0
+	return in.eval(node, env)
+}
 
 func isError(obj object.Object) bool {
 	if obj != nil {
@@ -23,43 +71,79 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func Eval(node ast.Node, env *object.Enviroment) object.Object {
+func isReturnValue(obj object.Object) bool {
+	if obj != nil {
+		return obj.Type() == object.RETURN_VALUE_OBJ
+	}
+	return false
+}
+
+func (in *Interpreter) eval(node ast.Node, env *object.Enviroment) object.Object {
+	var result object.Object
+	if err := in.step(); err != nil {
+		result = err
+	} else {
+		result = in.evalNode(node, env)
+	}
+	//在最先得到错误的节点处记录调用栈
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		err.Stack = in.traceback(node.Pos())
+	}
+	return result
+}
+
+func (in *Interpreter) evalNode(node ast.Node, env *object.Enviroment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return in.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return in.eval(node.Expression, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		//函数内的 return f(...) 是尾调用
+		if call, ok := node.ReturnValue.(*ast.CallExpression); ok && in.depth > 0 && in.tries == 0 {
+			val := in.evalTailCall(call, env)
+			if isError(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+		val := in.eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
-		//原代码
-		//return &object.ReturnValue{Value: val}
-
-		//修改后的代码
-		//return convertReturnValue(object.ReturnValue{Value: val})
-
-		//最终定稿
-		return val
+		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := in.eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return evalThrow(val)
+	case *ast.TryExpression:
+		return in.evalTryExpression(node, env)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := in.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := in.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := in.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
+		if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ && node.Operator == "+" {
+			size := int64(len(left.(*object.String).Value) + len(right.(*object.String).Value))
+			if err := in.checkAlloc(size); err != nil {
+				return err
+			}
+		}
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return in.evalIfExpression(node, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{
 			Value: node.Value,
@@ -67,23 +151,53 @@ func Eval(node ast.Node, env *object.Enviroment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := in.eval(node.Value, env)
+		if isError(val) || isReturnValue(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Name.Value
+		}
+		if node.Name.Local {
+			env.SetSlot(node.Name.Depth, node.Name.Slot, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
 	case *ast.AssignStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := in.eval(node.Value, env)
+		if isError(val) || isReturnValue(val) {
 			return val
 		}
+		if node.Name.Local {
+			if env.GetSlot(node.Name.Depth, node.Name.Slot) == nil {
+				return object.NewError("unknown identier:%v", node.Name.Value)
+			}
+			env.SetSlot(node.Name.Depth, node.Name.Slot, val)
+			return nil
+		}
 		_, ok, env2 := env.Get(node.Name.Value)
 		if !ok {
 			return object.NewError("unknown identier:%v", node.Name.Value)
 		}
 		env2.Set(node.Name.Value, val)
+	case *ast.MemberAssignStatement:
+		obj := in.eval(node.Target.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		val := in.eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return evalMemberAssign(obj, node.Target.Property.Value, val)
 	case *ast.Identifier:
 		return evalIdenfier(node, env)
+	case *ast.MemberExpression:
+		obj := in.eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Property.Value)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
@@ -93,26 +207,25 @@ func Eval(node ast.Node, env *object.Enviroment) object.Object {
 			Body:       body,
 		}
 	case *ast.WhileExpression:
-		return evalWhileExpression(node, env)
+		return in.evalWhileExpression(node, env)
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := in.eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpression(node.Arguments, env)
+		args := in.evalExpression(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return in.applyFunction(function, args, node.Function.Pos())
 	case *ast.PrintlnExpression:
-		args := evalExpression(node.Arguments, env)
+		args := in.evalExpression(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyPrintln(args)
+		return in.applyPrintln(args)
 	case *ast.BlockStatement:
-		extendedEnv := object.NewEnclosedEnvironment(env)
-		return evalBlockStatements(node, extendedEnv)
+		return in.evalBlockStatements(node, blockEnv(node, env))
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	}
@@ -140,56 +253,152 @@ func Eval(node ast.Node, env *object.Enviroment) object.Object {
 // 	}
 // }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	function, ok := fn.(*object.Function)
-	if !ok {
+// site 为调用位置
+func (in *Interpreter) applyFunction(fn object.Object, args []object.Object, site token.Position) object.Object {
+	switch function := fn.(type) {
+	case *object.Function:
+		if len(args) < len(function.Parameters) {
+			return object.NewError("wrong number of arguments. got=%d, want=%d", len(args), len(function.Parameters))
+		}
+		if err := in.enterCall(); err != nil {
+			return err
+		}
+		in.calls = append(in.calls, call{name: functionName(function), site: site})
+		tries := in.tries
+		in.tries = 0
+		defer func() {
+			in.tries = tries
+			in.calls = in.calls[:len(in.calls)-1]
+			in.leaveCall()
+		}()
+		//尾调用不增加Go栈深度：函数体返回 tailCall 时在此循环中继续执行被调函数
+		for {
+			extendedEnv := extendFunctionEnv(function, args)
+			evaluated := unwrapReturnValue(in.evalTailBlock(function.Body, blockEnv(function.Body, extendedEnv)))
+			tc, ok := evaluated.(*tailCall)
+			if !ok {
+				//函数体最后是 let、赋值或空的块时没有值，返回 null
+				if evaluated == nil {
+					return NULL
+				}
+				return evaluated
+			}
+			function, args = tc.fn, tc.args
+			//尾调用替换当前栈帧的函数，调用位置仍是调用者中最初的调用
+			in.calls[len(in.calls)-1].name = functionName(function)
+			if len(args) < len(function.Parameters) {
+				return object.NewError("wrong number of arguments. got=%d, want=%d", len(args), len(function.Parameters))
+			}
+		}
+	case *object.Builtin:
+		if result := function.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
+	default:
 		return object.NewError("not a function: %s", fn.Type())
 	}
-	extendedEnv := extendFunctionEnv(function, args)
-	evaluated := Eval(function.Body, extendedEnv)
-	return unwrapReturnValue(evaluated)
 }
 
-func applyPrintln(args []object.Object) object.Object {
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// 由当前调用栈生成错误的调用栈，pos 为出错位置
+func (in *Interpreter) traceback(pos token.Position) []object.Frame {
+	frames := make([]object.Frame, 0, len(in.calls)+1)
+	name := "<main>"
+	for _, c := range in.calls {
+		frames = append(frames, object.Frame{Function: name, Line: c.site.Line, Column: c.site.Column})
+		name = c.name
+	}
+	return append(frames, object.Frame{Function: name, Line: pos.Line, Column: pos.Column})
+}
+
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	getter, ok := obj.(object.MemberGetter)
+	if !ok {
+		return object.NewError("type error: %s has no member %s", obj.Type(), name)
+	}
+	return getter.GetMember(name)
+}
+
+func evalMemberAssign(obj object.Object, name string, val object.Object) object.Object {
+	setter, ok := obj.(object.MemberSetter)
+	if !ok {
+		return object.NewError("type error: cannot assign member %s of %s", name, obj.Type())
+	}
+	if err := setter.SetMember(name, val); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (in *Interpreter) applyPrintln(args []object.Object) object.Object {
 	var out []string
 	for _, arg := range args {
+		if arg == nil {
+			arg = NULL
+		}
 		out = append(out, arg.Inspect())
 	}
-	OUT = append(OUT, strings.Join(out, ""))
-	//fmt.Println(strings.Join(out, ""))
+	_, _ = io.WriteString(in.Stdout, strings.Join(out, "")+"\n")
 	return unwrapReturnValue(NULL)
 }
 
+// 参数按 resolver 分配的槽位存放，没有参数时不创建作用域
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviroment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	if len(fn.Parameters) == 0 {
+		return fn.Env
+	}
+	env := object.NewLocalEnvironment(fn.Env, len(fn.Parameters))
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		env.SetSlot(0, param.Slot, args[paramIdx])
 	}
 	return env
 }
 
-func evalWhileExpression(ie *ast.WhileExpression, env *object.Enviroment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
-		return condition
+// 块中有 let 时为其创建局部作用域
+func blockEnv(block *ast.BlockStatement, env *object.Enviroment) *object.Enviroment {
+	if block.NumSlots == 0 {
+		return env
 	}
-	for ; isTruthy(condition); condition = Eval(ie.Condition, env) {
-		Eval(ie.Body, env)
+	return object.NewLocalEnvironment(env, block.NumSlots)
+}
+
+func (in *Interpreter) evalWhileExpression(ie *ast.WhileExpression, env *object.Enviroment) object.Object {
+	for {
+		condition := in.eval(ie.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		result := in.eval(ie.Body, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
 	}
-	return NULL
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
-		return returnValue
+		return returnValue.Value
 	}
 	return obj
 }
 
-func evalExpression(exps []ast.Expression, env *object.Enviroment) []object.Object {
+func (in *Interpreter) evalExpression(exps []ast.Expression, env *object.Enviroment) []object.Object {
 	var result []object.Object
 	for _, e := range exps {
-		evaluated := Eval(e, env)
+		evaluated := in.eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -199,6 +408,12 @@ func evalExpression(exps []ast.Expression, env *object.Enviroment) []object.Obje
 }
 
 func evalIdenfier(node *ast.Identifier, env *object.Enviroment) object.Object {
+	if node.Local {
+		if val := env.GetSlot(node.Depth, node.Slot); val != nil {
+			return val
+		}
+		return object.NewError("identifier not found: " + node.Value)
+	}
 	val, ok, _ := env.Get(node.Value)
 	if !ok {
 		return object.NewError("identifier not found: " + node.Value)
@@ -206,10 +421,10 @@ func evalIdenfier(node *ast.Identifier, env *object.Enviroment) object.Object {
 	return val
 }
 
-func evalProgram(program *ast.Program, env *object.Enviroment) object.Object {
+func (in *Interpreter) evalProgram(program *ast.Program, env *object.Enviroment) object.Object {
 	var result object.Object
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
@@ -220,10 +435,10 @@ func evalProgram(program *ast.Program, env *object.Enviroment) object.Object {
 	return result
 }
 
-func evalBlockStatements(block *ast.BlockStatement, env *object.Enviroment) object.Object {
+func (in *Interpreter) evalBlockStatements(block *ast.BlockStatement, env *object.Enviroment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 		if result != nil {
 			rt := result.Type()
 			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
@@ -280,17 +495,17 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Enviroment) object.Object {
-	condition := Eval(ie.Condition, env)
+func (in *Interpreter) evalIfExpression(ie *ast.IfExpression, env *object.Enviroment) object.Object {
+	condition := in.eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return in.eval(ie.Consequence, env)
 	} else if ie.Another != nil {
-		return Eval(ie.Another, env)
+		return in.eval(ie.Another, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return in.eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
@@ -334,6 +549,9 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return object.NewError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	//逻辑运算符
 	case "<":
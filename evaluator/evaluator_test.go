@@ -4,7 +4,14 @@ import (
 	"TLanguage/lexer"
 	"TLanguage/object"
 	"TLanguage/parser"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -147,6 +154,10 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"10 / (5 - 5)",
+			"division by zero",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -219,6 +230,23 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+// 没有值的函数调用结果为 null，可以传给 println
+func TestPrintlnFunctionWithoutValue(t *testing.T) {
+	input := `let h = fn() { if (true) { } };
+	let g = fn() { let x = 1; };
+	let k = fn() { let y = 1; y = 2; };
+	println(h(), " ", g(), " ", k());`
+	var out bytes.Buffer
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	result := New(&out, io.Discard).Eval(program, object.NewEnvironment())
+	if err, ok := result.(*object.Error); ok {
+		t.Fatalf("unexpected error %s", err.Inspect())
+	}
+	if out.String() != "null null null\n" {
+		t.Errorf("expected %q, got %q", "null null null\n", out.String())
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 	let newAdder = fn(x) {
@@ -295,3 +323,310 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	}
 	return true
 }
+
+func TestPrintlnWritesToInterpreterOutput(t *testing.T) {
+	input := `
+	let i = 0;
+	while (i < 100) {
+		println("n=", n, " i=", i);
+		i = i + 1;
+	}`
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 8)
+	for n := range outputs {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+			env := object.NewEnvironment()
+			env.Set("n", &object.Integer{Value: int64(n)})
+			New(&outputs[n], io.Discard).Eval(program, env)
+		}(n)
+	}
+	wg.Wait()
+	for n := range outputs {
+		lines := strings.Split(strings.TrimSuffix(outputs[n].String(), "\n"), "\n")
+		if len(lines) != 100 {
+			t.Fatalf("interpreter %d wrote %d lines, expected 100", n, len(lines))
+		}
+		for i, line := range lines {
+			expected := fmt.Sprintf("n=%d i=%d", n, i)
+			if line != expected {
+				t.Fatalf("interpreter %d line %d: expected %q, got %q", n, i, expected, line)
+			}
+		}
+	}
+}
+
+// 同一棵语法树可以同时在多个解释器中求值，求值不修改语法树
+func TestConcurrentEvalSharedProgram(t *testing.T) {
+	input := `
+	let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+	let total = 0;
+	let i = 0;
+	while (i < 10) { let f = fn(x) { x + i }; total = total + f(fib(i)); i = i + 1; }
+	total`
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	var wg sync.WaitGroup
+	results := make([]object.Object, 4)
+	for n := range results {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results[n] = New(io.Discard, io.Discard).Eval(program, object.NewEnvironment())
+		}(n)
+	}
+	wg.Wait()
+	for _, result := range results {
+		testIntegerObject(t, result, 133)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input        string
+		limits       Limits
+		expectedKind string
+	}{
+		{"while(true){}", Limits{MaxSteps: 10000}, object.STEP_LIMIT_ERROR},
+		{"while(true){}", Limits{Timeout: 20 * time.Millisecond}, object.TIMEOUT_ERROR},
+		{"let fab = fn(x){ return fab(x-2) + fab(x-1); }; fab(10);", Limits{MaxDepth: 100}, object.DEPTH_LIMIT_ERROR},
+		{"let fab = fn(x){ return fab(x-2) + fab(x-1); }; fab(10);", Limits{}, object.DEPTH_LIMIT_ERROR},
+		{`let s = "ab"; while(true){ s = s + s; }`, Limits{MaxAllocSize: 1 << 20}, object.ALLOC_LIMIT_ERROR},
+	}
+	for _, tt := range tests {
+		program := parser.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		in := New(io.Discard, io.Discard)
+		in.Limits = tt.limits
+		evaluated := in.Eval(program, object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. Got %T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("%q: wrong error kind. expected %q, got %q (%s)", tt.input, tt.expectedKind, errObj.Kind, errObj.Message)
+		}
+	}
+}
+
+func TestEvalContextCanceled(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer("while(true){}")).ParseProgram()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	evaluated := New(io.Discard, io.Discard).EvalContext(ctx, program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.CANCELED_ERROR {
+		t.Fatalf("expected %s error. Got %T(%+v)", object.CANCELED_ERROR, evaluated, evaluated)
+	}
+}
+
+func TestReturnStopsFunctionBody(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn(x){ if (x > 1) { return 1; } return 2; }; f(5);", 1},
+		{"let f = fn(x){ if (x > 1) { return 1; } return 2; }; f(0);", 2},
+		{"let f = fn(){ let i = 0; while (true) { if (i == 3) { return i; } i = i + 1; } }; f();", 3},
+		{"return 10; 9;", 10},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let sum = fn(n, acc){ if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(1000000, 0);", 500000500000},
+		{"let sum = fn(n, acc){ if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000000, 0);", 500000500000},
+		{`let even = fn(n){ if (n == 0) { return 1; } odd(n - 1) };
+		  let odd = fn(n){ if (n == 0) { return 0; } even(n - 1) };
+		  even(1000001);`, 0},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(a) {
+	a + missing;
+};
+let outer = fn() {
+	let r = inner(1);
+	r;
+};
+outer();`
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. Got %T(%+v)", evaluated, evaluated)
+	}
+	expected := []object.Frame{
+		{Function: "<main>", Line: 8, Column: 1},
+		{Function: "outer", Line: 5, Column: 10},
+		{Function: "inner", Line: 2, Column: 6},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. expected %d, got %d: %+v", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("stack[%d] wrong. expected %+v, got %+v", i, frame, errObj.Stack[i])
+		}
+	}
+	traceback := errObj.Traceback("test.tl")
+	expectedTraceback := `Traceback (most recent call last):
+  File "test.tl", line 8, column 1, in <main>
+  File "test.tl", line 5, column 10, in outer
+  File "test.tl", line 2, column 6, in inner
+RuntimeError: identifier not found: missing
+`
+	if traceback != expectedTraceback {
+		t.Errorf("wrong traceback. expected\n%s\ngot\n%s", expectedTraceback, traceback)
+	}
+}
+
+// 递归过深时调用栈有上万层，连续重复的帧在 traceback 中合并为一行
+func TestRecursionTraceback(t *testing.T) {
+	tests := []struct {
+		input    string
+		repeated string
+	}{
+		{"let f = fn(n) { 1 + f(n) };\nf(0);", "  [previous frame repeated 9996 more times]\n"},
+		{"let a = fn(n) { 1 + b(n) };\nlet b = fn(n) { 1 + a(n) };\na(0);", "  [previous 2 frames repeated 4996 more times]\n"},
+	}
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok || errObj.Kind != object.DEPTH_LIMIT_ERROR {
+			t.Fatalf("expected a RecursionError, got %v", errObj)
+		}
+		traceback := errObj.Traceback("test.tl")
+		if !strings.Contains(traceback, tt.repeated) {
+			t.Errorf("traceback should contain %q, got\n%s", tt.repeated, traceback)
+		}
+		if lines := strings.Count(traceback, "\n"); lines > 12 {
+			t.Errorf("traceback should be collapsed, got %d lines", lines)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { throw "boom"; } catch (e) { e.message }`, "boom"},
+		{`try { throw "boom"; } catch (e) { e.kind }`, "Error"},
+		{`try { 1 + true } catch (e) { e.message }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`try { missing } catch (e) { e.kind }`, "RuntimeError"},
+		{`try { 10 } catch (e) { 20 }`, 10},
+		{`let f = fn(){ throw "inner"; }; try { f(); } catch (e) { e.stack }`, "<main>:1:39\nf:1:15"},
+		{`let r = 0; try { throw 1; } catch (e) { r = r + 1; } finally { r = r + 10; }; r`, 11},
+		{`let r = 0; let f = fn(){ try { return 1; } finally { r = 5; } }; f() + r`, 6},
+		{`let f = fn(){ try { return 1; } finally { return 2; } }; f()`, 2},
+		{`let f = fn(x){ if (x == 0) { throw "done"; } return f(x - 1); };
+		  try { f(3); } catch (e) { e.message }`, "done"},
+		{`try { try { throw "a"; } catch (e) { throw e; } } catch (e2) { e2.message }`, "a"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. Got %T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%q: expected %q, got %q", tt.input, expected, str.Value)
+			}
+		}
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind string
+		expectedMsg  string
+	}{
+		{`throw "boom"; 5`, object.THROWN_ERROR, "boom"},
+		{`try { throw "a"; } finally { 1 }`, object.THROWN_ERROR, "a"},
+		{`try { throw "a"; } catch (e) { throw "b"; }`, object.THROWN_ERROR, "b"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. Got %T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind || errObj.Message != tt.expectedMsg {
+			t.Errorf("%q: expected %s: %s, got %s: %s", tt.input, tt.expectedKind, tt.expectedMsg, errObj.Kind, errObj.Message)
+		}
+	}
+}
+
+func TestLimitErrorsAreNotCatchable(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer("try { while(true){} } catch (e) { 1 }")).ParseProgram()
+	in := New(io.Discard, io.Discard)
+	in.Limits = Limits{MaxSteps: 1000}
+	evaluated := in.Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.STEP_LIMIT_ERROR {
+		t.Fatalf("expected %s error. Got %T(%+v)", object.STEP_LIMIT_ERROR, evaluated, evaluated)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; if (true) { x = 2; }; x", 2},
+		{"let f = fn(x){ let x = x + 1; x }; f(1)", 2},
+		{"let f = fn(x, x){ x }; f(1, 2)", 2},
+		{"let f = fn(){ let a = 1; let g = fn(){ a = a + 1; a }; g(); g() }; f()", 3},
+		{"let counter = fn(){ let n = 0; fn(){ n = n + 1; n } }; let c = counter(); c(); c()", 2},
+		{`let f = fn(){
+			let isEven = fn(n){ if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n){ if (n == 0) { false } else { isEven(n - 1) } };
+			if (isEven(10)) { 1 } else { 0 }
+		}; f()`, 1},
+		{"let i = 0; let sum = 0; while (i < 5) { let d = i * 2; sum = sum + d; i = i + 1; }; sum", 20},
+		{"let f = fn(){ try { throw 1; } catch (e) { let m = e.message; m } }; f()", "1"},
+		{"let f = fn(){ if (true) { let y = 1; }; y }; f()", "identifier not found: y"},
+		{"let f = fn(){ z = 1; }; f()", "unknown identier:z"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%q: expected %q, got %q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%q: expected error %q, got %q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%q: expected %q, got %T(%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+}
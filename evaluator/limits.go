@@ -0,0 +1,59 @@
+package evaluator
+
+import (
+	"TLanguage/object"
+	"context"
+	"errors"
+	"time"
+)
+
+// 未设置 MaxDepth 时的调用深度上限，防止无限递归耗尽Go的栈
+const DefaultMaxDepth = 10000
+
+// 执行限制，字段为零值时表示不限制
+type Limits struct {
+	MaxSteps     int64         //最多求值的节点数
+	Timeout      time.Duration //最长运行时间
+	MaxDepth     int           //最大函数调用深度，为0时使用 DefaultMaxDepth
+	MaxAllocSize int64         //单个字符串的最大字节数
+}
+
+// 每求值一个节点调用一次，每256步检查一次ctx
+func (in *Interpreter) step() *object.Error {
+	in.steps++
+	if in.Limits.MaxSteps > 0 && in.steps > in.Limits.MaxSteps {
+		return object.NewErrorKind(object.STEP_LIMIT_ERROR, "step limit exceeded: %d", in.Limits.MaxSteps)
+	}
+	if in.steps&0xff == 0 && in.ctx != nil {
+		if err := in.ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return object.NewErrorKind(object.TIMEOUT_ERROR, "execution timed out")
+			}
+			return object.NewErrorKind(object.CANCELED_ERROR, "execution canceled")
+		}
+	}
+	return nil
+}
+
+func (in *Interpreter) enterCall() *object.Error {
+	maxDepth := in.Limits.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if in.depth >= maxDepth {
+		return object.NewErrorKind(object.DEPTH_LIMIT_ERROR, "maximum call depth exceeded: %d", maxDepth)
+	}
+	in.depth++
+	return nil
+}
+
+func (in *Interpreter) leaveCall() {
+	in.depth--
+}
+
+func (in *Interpreter) checkAlloc(size int64) *object.Error {
+	if in.Limits.MaxAllocSize > 0 && size > in.Limits.MaxAllocSize {
+		return object.NewErrorKind(object.ALLOC_LIMIT_ERROR, "string of %d bytes exceeds allocation limit %d", size, in.Limits.MaxAllocSize)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package evaluator
+
+import (
+	"TLanguage/ast"
+	"TLanguage/object"
+)
+
+const TAIL_CALL_OBJ = "TAIL_CALL"
+
+// 处于尾位置的函数调用，由 applyFunction 循环执行而不是递归求值
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType {
+	return TAIL_CALL_OBJ
+}
+
+func (tc *tailCall) Inspect() string {
+	return "tail call"
+}
+
+// 求出被调函数与参数，被调者为T函数时返回 tailCall，否则直接调用
+func (in *Interpreter) evalTailCall(call *ast.CallExpression, env *object.Enviroment) object.Object {
+	function := in.eval(call.Function, env)
+	if isError(function) {
+		return function
+	}
+	args := in.evalExpression(call.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	if fn, ok := function.(*object.Function); ok {
+		return &tailCall{fn: fn, args: args}
+	}
+	return in.applyFunction(function, args, call.Function.Pos())
+}
+
+// 求值函数体，最后一条语句处于尾位置
+func (in *Interpreter) evalTailBlock(block *ast.BlockStatement, env *object.Enviroment) object.Object {
+	var result object.Object
+	for i, statement := range block.Statements {
+		if i == len(block.Statements)-1 {
+			return in.evalTailStatement(statement, env)
+		}
+		result = in.eval(statement, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+func (in *Interpreter) evalTailStatement(statement ast.Statement, env *object.Enviroment) object.Object {
+	stmt, ok := statement.(*ast.ExpressionStatement)
+	if !ok {
+		return in.eval(statement, env)
+	}
+	if err := in.step(); err != nil {
+		return err
+	}
+	switch exp := stmt.Expression.(type) {
+	case *ast.CallExpression:
+		return in.evalTailCall(exp, env)
+	case *ast.IfExpression:
+		return in.evalTailIf(exp, env)
+	default:
+		return in.eval(statement, env)
+	}
+}
+
+// 与 evalIfExpression 相同，但所选分支的最后一条语句处于尾位置
+func (in *Interpreter) evalTailIf(ie *ast.IfExpression, env *object.Enviroment) object.Object {
+	condition := in.eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+	if isTruthy(condition) {
+		return in.evalTailBlock(ie.Consequence, blockEnv(ie.Consequence, env))
+	} else if ie.Another != nil {
+		return in.evalTailIf(ie.Another, env)
+	} else if ie.Alternative != nil {
+		return in.evalTailBlock(ie.Alternative, blockEnv(ie.Alternative, env))
+	} else {
+		return NULL
+	}
+}
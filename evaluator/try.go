@@ -0,0 +1,45 @@
+package evaluator
+
+import (
+	"TLanguage/ast"
+	"TLanguage/object"
+)
+
+// throw 捕获到的错误时原样抛出，字符串作为错误信息，其他值使用其 Inspect 结果
+func evalThrow(val object.Object) object.Object {
+	switch val := val.(type) {
+	case *object.Exception:
+		return val.Error
+	case *object.String:
+		return object.NewErrorKind(object.THROWN_ERROR, "%s", val.Value)
+	default:
+		return object.NewErrorKind(object.THROWN_ERROR, "%s", val.Inspect())
+	}
+}
+
+func (in *Interpreter) evalTryExpression(te *ast.TryExpression, env *object.Enviroment) object.Object {
+	in.tries++
+	defer func() {
+		in.tries--
+	}()
+	result := in.eval(te.Block, env)
+	if err, ok := result.(*object.Error); ok && err.Catchable() && te.Catch != nil {
+		catchEnv := object.NewLocalEnvironment(env, 1)
+		catchEnv.SetSlot(0, te.Param.Slot, &object.Exception{Error: err})
+		result = in.eval(te.Catch, catchEnv)
+	}
+	if te.Finally != nil {
+		if err, ok := result.(*object.Error); ok && !err.Catchable() {
+			return result
+		}
+		//finally 中的错误或 return 覆盖原结果
+		finally := in.eval(te.Finally, env)
+		if isError(finally) || isReturnValue(finally) {
+			return finally
+		}
+	}
+	if result == nil {
+		return NULL
+	}
+	return result
+}
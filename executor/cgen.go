@@ -0,0 +1,558 @@
+package executor
+
+import (
+	"TLanguage/ast"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// T变量在C中的表示
+type cVar struct {
+	name    string
+	cname   string
+	fn      *cFunc //所属的C函数，顶层变量为 nil(C的静态变量)
+	cell    bool   //被内部函数或 try 引用，存放在 tl_cell 中并在块开头提前声明
+	defined bool   //按生成顺序已经执行过 let
+}
+
+// T的块作用域，对应生成代码中的一个C块
+type cScope struct {
+	vars   map[string]*cVar
+	order  []*cVar //已声明的局部变量，离开作用域或 return 时释放
+	fn     *cFunc
+	global bool
+	outer  *cScope
+}
+
+// 生成的一个C函数。函数字面量与 try/catch/finally 的每一部分各对应一个C函数
+type cFunc struct {
+	name    string
+	out     bytes.Buffer
+	indent  int
+	level   int  //函数嵌套层数，try 的各部分与所在函数相同
+	try     bool //try 的一部分，return 时需设置 *returned
+	free    []*cVar
+	freeIdx map[*cVar]int
+}
+
+type cGenerator struct {
+	funcs   []*cFunc
+	globals []*cVar
+	fn      *cFunc
+	scope   *cScope
+	temps   int
+	err     error
+}
+
+// GenerateC 将T程序翻译为等价的C99程序源码
+func GenerateC(program *ast.Program) ([]byte, error) {
+	g := &cGenerator{}
+	main := g.newFunc("tl_main", 0, false)
+	g.fn = main
+	g.scope = &cScope{vars: make(map[string]*cVar), fn: main, global: true}
+	for _, s := range program.Statements {
+		if let, ok := s.(*ast.LetStatement); ok && g.scope.vars[let.Name.Value] == nil {
+			v := &cVar{name: let.Name.Value, cname: "g_" + let.Name.Value}
+			g.scope.vars[v.name] = v
+			g.globals = append(g.globals, v)
+		}
+	}
+	for _, s := range program.Statements {
+		g.statement(s, "")
+	}
+	g.line("return tl_null();")
+	g.funcs = append(g.funcs, main)
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(cRuntimeSource)
+	out.WriteString("\n")
+	for _, f := range g.funcs {
+		fmt.Fprintf(&out, "static tl_value %s(tl_closure *self, tl_value *args, int *returned);\n", f.name)
+	}
+	out.WriteString("\n")
+	for _, v := range g.globals {
+		fmt.Fprintf(&out, "static tl_value %s;\n", v.cname)
+	}
+	for _, f := range g.funcs {
+		fmt.Fprintf(&out, "\nstatic tl_value %s(tl_closure *self, tl_value *args, int *returned) {\n", f.name)
+		out.WriteString("\t(void)self;\n\t(void)args;\n\t(void)returned;\n")
+		out.Write(f.out.Bytes())
+		out.WriteString("}\n")
+	}
+	out.WriteString(cMainSource)
+	return out.Bytes(), nil
+}
+
+func (g *cGenerator) newFunc(name string, level int, try bool) *cFunc {
+	return &cFunc{name: name, indent: 1, level: level, try: try, freeIdx: make(map[*cVar]int)}
+}
+
+// 按花括号缩进输出一行
+func (g *cGenerator) line(format string, a ...interface{}) {
+	text := fmt.Sprintf(format, a...)
+	f := g.fn
+	if strings.HasPrefix(text, "}") {
+		f.indent--
+	}
+	f.out.WriteString(strings.Repeat("\t", f.indent))
+	f.out.WriteString(text)
+	f.out.WriteString("\n")
+	if strings.HasSuffix(text, "{") {
+		f.indent++
+	}
+}
+
+func (g *cGenerator) newTemp(prefix string) string {
+	g.temps++
+	return fmt.Sprintf("%s%d", prefix, g.temps)
+}
+
+// 将表达式的值保存到临时变量中，返回变量名。所有子表达式都先求值到临时变量，以保持从左到右的求值顺序
+func (g *cGenerator) temp(value string) string {
+	t := g.newTemp("t")
+	g.line("tl_value %s = %s;", t, value)
+	return t
+}
+
+// 在当前作用域中声明局部变量
+func (g *cGenerator) declare(name string, cell bool) *cVar {
+	g.temps++
+	prefix := "v"
+	if cell {
+		prefix = "c"
+	}
+	v := &cVar{name: name, cname: fmt.Sprintf("%s%d_%s", prefix, g.temps, name), fn: g.fn, cell: cell}
+	g.scope.vars[name] = v
+	g.scope.order = append(g.scope.order, v)
+	return v
+}
+
+// 与 resolver 的规则相同：直接执行的代码只能看到已经执行过 let 的变量，
+// 嵌套函数可以看到作用域中的全部变量
+func (g *cGenerator) lookup(name string) (*cVar, bool) {
+	for s := g.scope; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok && (v.defined || g.fn.level > s.fn.level) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// 当前函数中存放变量值的C左值
+func (g *cGenerator) slot(v *cVar) string {
+	switch {
+	case v.fn == nil:
+		return v.cname
+	case v.fn == g.fn && v.cell:
+		return v.cname + "->v"
+	case v.fn == g.fn:
+		return v.cname
+	}
+	return fmt.Sprintf("self->free[%d]->v", g.freeIndex(g.fn, v))
+}
+
+// 在函数 f 中取得变量 v 所在的 tl_cell
+func (g *cGenerator) cellRef(f *cFunc, v *cVar) string {
+	if v.fn == f {
+		return v.cname
+	}
+	return fmt.Sprintf("self->free[%d]", g.freeIndex(f, v))
+}
+
+// v 在函数 f 捕获的变量中的下标，第一次引用时加入
+func (g *cGenerator) freeIndex(f *cFunc, v *cVar) int {
+	if !v.cell && g.err == nil {
+		g.err = fmt.Errorf("variable %s is captured but not stored in a cell", v.name)
+	}
+	if i, ok := f.freeIdx[v]; ok {
+		return i
+	}
+	f.freeIdx[v] = len(f.free)
+	f.free = append(f.free, v)
+	return len(f.free) - 1
+}
+
+// 出现在嵌套函数或 try 中的名字，对应的变量需要存放在 tl_cell 中
+func capturedNames(nodes ...ast.Node) map[string]bool {
+	captured := make(map[string]bool)
+	collect := func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			captured[ident.Value] = true
+		}
+		return true
+	}
+	for _, node := range nodes {
+		ast.Walk(node, func(n ast.Node) bool {
+			switch n.(type) {
+			case *ast.FunctionLiteral, *ast.TryExpression:
+				ast.Walk(n, collect)
+				return false
+			}
+			return true
+		})
+	}
+	return captured
+}
+
+// 进入新的块作用域，块中被捕获的 let 变量在块开头提前创建 tl_cell
+func (g *cGenerator) pushScope(stmts []ast.Statement) {
+	g.scope = &cScope{vars: make(map[string]*cVar), fn: g.fn, outer: g.scope}
+	nodes := make([]ast.Node, len(stmts))
+	for i, s := range stmts {
+		nodes[i] = s
+	}
+	captured := capturedNames(nodes...)
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !captured[let.Name.Value] || g.scope.vars[let.Name.Value] != nil {
+			continue
+		}
+		v := g.declare(let.Name.Value, true)
+		g.line("tl_cell *%s = tl_cell_new(tl_undef());", v.cname)
+	}
+}
+
+func (g *cGenerator) popScope() {
+	g.scope = g.scope.outer
+}
+
+// 释放作用域中已声明的变量
+func (g *cGenerator) release(s *cScope) {
+	for _, v := range s.order {
+		if v.cell {
+			g.line("tl_cell_release(%s);", v.cname)
+		} else {
+			g.line("tl_release(%s);", v.cname)
+		}
+	}
+}
+
+// 生成一个块，result 不为空时将块的值(最后一条语句的值)赋给 result。
+// tail 为 true 时块的最后一条语句处于函数体的尾位置
+func (g *cGenerator) block(stmts []ast.Statement, result string, tail bool) {
+	g.line("{")
+	g.pushScope(stmts)
+	for i, s := range stmts {
+		switch {
+		case i < len(stmts)-1:
+			g.statement(s, "")
+		case tail:
+			g.tailStatement(s, result)
+		default:
+			g.statement(s, result)
+		}
+	}
+	g.release(g.scope)
+	g.popScope()
+	g.line("}")
+}
+
+// 释放当前函数中的全部局部变量后返回
+func (g *cGenerator) returnStatement(value string) {
+	for s := g.scope; s != nil && s.fn == g.fn; s = s.outer {
+		if !s.global {
+			g.release(s)
+		}
+	}
+	if g.fn.try {
+		g.line("*returned = 1;")
+	}
+	g.line("return %s;", value)
+}
+
+func (g *cGenerator) statement(stmt ast.Statement, result string) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		name := s.Name.Value
+		var value string
+		if fn, ok := s.Value.(*ast.FunctionLiteral); ok {
+			value = g.funcLit(fn, name)
+		} else {
+			value = g.expr(s.Value)
+		}
+		if v, ok := g.scope.vars[name]; ok && (v.cell || v.fn == nil || v.defined) {
+			g.line("tl_define(&%s, %s);", g.slot(v), value)
+			v.defined = true
+		} else {
+			v := g.declare(name, false)
+			g.line("tl_value %s = %s;", v.cname, value)
+			v.defined = true
+		}
+	case *ast.AssignStatement:
+		value := g.expr(s.Value)
+		if v, ok := g.lookup(s.Name.Value); ok {
+			g.line("tl_assign(&%s, %s, %s);", g.slot(v), value, cQuote(s.Name.Value))
+		} else {
+			g.line("tl_assign_undefined(%s, %s);", value, cQuote(s.Name.Value))
+		}
+	case *ast.MemberAssignStatement:
+		obj := g.expr(s.Target.Object)
+		g.line("tl_set_member(%s, %s, %s);", obj, cQuote(s.Target.Property.Value), g.expr(s.Value))
+	case *ast.ReturnStatement:
+		//函数内 try 之外的 return f(...) 是尾调用
+		if call, ok := s.ReturnValue.(*ast.CallExpression); ok && g.fn.level > 0 && !g.fn.try {
+			g.returnStatement(g.tailCall(call))
+		} else {
+			g.returnStatement(g.expr(s.ReturnValue))
+		}
+	case *ast.ThrowStatement:
+		g.line("tl_throw(%s);", g.expr(s.Value))
+	case *ast.ExpressionStatement:
+		switch e := s.Expression.(type) {
+		case *ast.IfExpression:
+			g.ifStatement(e, result, false)
+		case *ast.WhileExpression:
+			g.whileStatement(e)
+		case *ast.TryExpression:
+			g.tryStatement(e, result)
+		default:
+			value := g.expr(e)
+			if result != "" {
+				g.line("tl_define(&%s, %s);", result, value)
+			} else {
+				g.line("tl_release(%s);", value)
+			}
+		}
+	}
+}
+
+// 与解释器的规则相同：函数体最后的调用表达式、if 所选分支中最后的调用以及 try 之外的
+// return f(...) 为尾调用，生成 tl_tail 而不是 tl_call，递归不受调用深度的限制
+func (g *cGenerator) tailStatement(stmt ast.Statement, result string) {
+	if s, ok := stmt.(*ast.ExpressionStatement); ok {
+		switch e := s.Expression.(type) {
+		case *ast.CallExpression:
+			g.line("tl_define(&%s, %s);", result, g.tailCall(e))
+			return
+		case *ast.IfExpression:
+			g.ifStatement(e, result, true)
+			return
+		}
+	}
+	g.statement(stmt, result)
+}
+
+// 求出被调函数与参数，返回 tl_tail 的结果
+func (g *cGenerator) tailCall(e *ast.CallExpression) string {
+	fn := g.expr(e.Function)
+	args := g.exprs(e.Arguments)
+	return g.temp(fmt.Sprintf("tl_tail(%s, %d, %s)", fn, len(e.Arguments), args))
+}
+
+var cOperators = map[string]string{
+	"+": "TL_ADD", "-": "TL_SUB", "*": "TL_MUL", "/": "TL_DIV",
+	"<": "TL_LT", ">": "TL_GT", "==": "TL_EQ", "!=": "TL_NE",
+}
+
+// 按顺序求值一组表达式，返回C数组参数
+func (g *cGenerator) exprs(list []ast.Expression) string {
+	if len(list) == 0 {
+		return "NULL"
+	}
+	values := make([]string, len(list))
+	for i, e := range list {
+		values[i] = g.expr(e)
+	}
+	return "(tl_value[]){" + strings.Join(values, ", ") + "}"
+}
+
+// 生成表达式，返回归调用方所有的值
+func (g *cGenerator) expr(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return fmt.Sprintf("tl_int(INT64_C(%d))", e.Value)
+	case *ast.StringLiteral:
+		return fmt.Sprintf("tl_str_new(%s, %d)", cQuote(e.Value), len(e.Value))
+	case *ast.Boolean:
+		if e.Value {
+			return "tl_bool(1)"
+		}
+		return "tl_bool(0)"
+	case *ast.Identifier:
+		v, ok := g.lookup(e.Value)
+		switch {
+		case !ok:
+			return g.temp(fmt.Sprintf("tl_undefined(%s)", cQuote(e.Value)))
+		case v.fn == g.fn && !v.cell:
+			return g.temp(fmt.Sprintf("tl_retain(%s)", v.cname))
+		}
+		return g.temp(fmt.Sprintf("tl_get(%s, %s)", g.slot(v), cQuote(e.Value)))
+	case *ast.PrefixExpression:
+		right := g.expr(e.Right)
+		if e.Operator == "!" {
+			return g.temp(fmt.Sprintf("tl_not(%s)", right))
+		}
+		return g.temp(fmt.Sprintf("tl_minus(%s)", right))
+	case *ast.InfixExpression:
+		left := g.expr(e.Left)
+		right := g.expr(e.Right)
+		op, ok := cOperators[e.Operator]
+		if !ok && g.err == nil {
+			g.err = fmt.Errorf("unsupported operator %s", e.Operator)
+		}
+		return g.temp(fmt.Sprintf("tl_infix(%s, %s, %s)", op, left, right))
+	case *ast.CallExpression:
+		fn := g.expr(e.Function)
+		args := g.exprs(e.Arguments)
+		return g.temp(fmt.Sprintf("tl_call(%s, %d, %s)", fn, len(e.Arguments), args))
+	case *ast.PrintlnExpression:
+		args := g.exprs(e.Arguments)
+		return g.temp(fmt.Sprintf("tl_println(%d, %s)", len(e.Arguments), args))
+	case *ast.MemberExpression:
+		obj := g.expr(e.Object)
+		return g.temp(fmt.Sprintf("tl_member(%s, %s)", obj, cQuote(e.Property.Value)))
+	case *ast.FunctionLiteral:
+		return g.funcLit(e, "")
+	case *ast.IfExpression:
+		t := g.newTemp("t")
+		g.line("tl_value %s = tl_null();", t)
+		g.ifStatement(e, t, false)
+		return t
+	case *ast.WhileExpression:
+		g.whileStatement(e)
+		return "tl_null()"
+	case *ast.TryExpression:
+		t := g.newTemp("t")
+		g.line("tl_value %s = tl_null();", t)
+		g.tryStatement(e, t)
+		return t
+	}
+	return "tl_null()"
+}
+
+// 生成函数字面量对应的C函数，返回创建闭包的临时变量
+func (g *cGenerator) funcLit(fn *ast.FunctionLiteral, name string) string {
+	f := g.newFunc(g.newTemp("fn_"), g.fn.level+1, false)
+	outerFn, outerScope := g.fn, g.scope
+	g.fn = f
+	g.scope = &cScope{vars: make(map[string]*cVar), fn: f, outer: outerScope}
+	captured := capturedNames(fn.Body)
+	for i, p := range fn.Parameters {
+		v := g.declare(p.Value, captured[p.Value])
+		v.defined = true
+		if v.cell {
+			g.line("tl_cell *%s = tl_cell_new(args[%d]);", v.cname, i)
+		} else {
+			g.line("tl_value %s = args[%d];", v.cname, i)
+		}
+	}
+	result := g.newTemp("r")
+	g.line("tl_value %s = tl_null();", result)
+	g.block(fn.Body.Statements, result, true)
+	g.release(g.scope)
+	g.line("return %s;", result)
+	g.fn, g.scope = outerFn, outerScope
+	g.funcs = append(g.funcs, f)
+	return g.closure(f, name, inspectFunction(fn), len(fn.Parameters))
+}
+
+// 在当前函数中为 f 创建闭包，捕获 f 引用的外层变量
+func (g *cGenerator) closure(f *cFunc, name, inspect string, params int) string {
+	cells := "NULL"
+	if len(f.free) > 0 {
+		refs := make([]string, len(f.free))
+		for i, v := range f.free {
+			refs[i] = g.cellRef(g.fn, v)
+		}
+		cells = "(tl_cell *[]){" + strings.Join(refs, ", ") + "}"
+	}
+	return g.temp(fmt.Sprintf("tl_closure_new(%s, %s, %s, %d, %d, %s)", f.name, cQuote(name), cQuote(inspect), params, len(f.free), cells))
+}
+
+// 将 try 的一部分生成为C函数，param 不为 nil 时为 catch 的参数
+func (g *cGenerator) tryPart(block *ast.BlockStatement, param *ast.Identifier) string {
+	f := g.newFunc(g.newTemp("try_"), g.fn.level, true)
+	outerFn, outerScope := g.fn, g.scope
+	g.fn = f
+	g.scope = &cScope{vars: make(map[string]*cVar), fn: f, outer: outerScope}
+	if param != nil {
+		v := g.declare(param.Value, capturedNames(block)[param.Value])
+		v.defined = true
+		if v.cell {
+			g.line("tl_cell *%s = tl_cell_new(args[0]);", v.cname)
+		} else {
+			g.line("tl_value %s = args[0];", v.cname)
+		}
+	}
+	result := g.newTemp("r")
+	g.line("tl_value %s = tl_null();", result)
+	g.block(block.Statements, result, false)
+	g.release(g.scope)
+	g.line("return %s;", result)
+	g.fn, g.scope = outerFn, outerScope
+	g.funcs = append(g.funcs, f)
+	return g.closure(f, "", "", 0)
+}
+
+// tail 为 true 时 if 处于函数体的尾位置，所选分支的最后一条语句同样处于尾位置
+func (g *cGenerator) ifStatement(e *ast.IfExpression, result string, tail bool) {
+	condition := g.expr(e.Condition)
+	g.line("if (tl_test(%s)) {", condition)
+	g.block(e.Consequence.Statements, result, tail)
+	switch {
+	case e.Another != nil:
+		g.line("} else {")
+		g.ifStatement(e.Another, result, tail)
+	case e.Alternative != nil:
+		g.line("} else {")
+		g.block(e.Alternative.Statements, result, tail)
+	}
+	g.line("}")
+}
+
+func (g *cGenerator) whileStatement(e *ast.WhileExpression) {
+	g.line("for (;;) {")
+	condition := g.expr(e.Condition)
+	g.line("if (!tl_test(%s)) {", condition)
+	g.line("break;")
+	g.line("}")
+	g.block(e.Body.Statements, "", false)
+	g.line("}")
+}
+
+func (g *cGenerator) tryStatement(e *ast.TryExpression, result string) {
+	block := g.tryPart(e.Block, nil)
+	catch, finally := "tl_null()", "tl_null()"
+	if e.Catch != nil {
+		catch = g.tryPart(e.Catch, e.Param)
+	}
+	if e.Finally != nil {
+		finally = g.tryPart(e.Finally, nil)
+	}
+	returned := g.newTemp("ret")
+	value := g.newTemp("t")
+	g.line("int %s = 0;", returned)
+	g.line("tl_value %s = tl_try(%s, %s, %s, &%s);", value, block, catch, finally, returned)
+	g.line("if (%s) {", returned)
+	g.returnStatement(value)
+	g.line("}")
+	if result != "" {
+		g.line("tl_define(&%s, %s);", result, value)
+	} else {
+		g.line("tl_release(%s);", value)
+	}
+}
+
+// C字符串字面量。非打印字符按字节使用八进制转义，? 转义以免构成三字符组
+func cQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '?':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
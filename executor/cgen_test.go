@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// 生成的C程序应与解释器的输出一致
+func TestGeneratedCProgramMatchesInterpreter(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not available")
+	}
+	inputs := append([]string{
+		`let counter = fn(){ let n = 0; fn(){ n = n + 1; n } };
+		let c = counter();
+		c(); c();
+		println(c(), " ", counter()());
+		let s = "a";
+		println(s == s, " ", "a" == "a", " ", null == null, " ", counter == counter);`,
+		`let find = fn(limit){
+			let i = 0;
+			while (true) {
+				let j = i * i;
+				if (j > limit) { return i; }
+				i = i + 1;
+			}
+		};
+		println(find(50), " ", 9223372036854775807 + 1, " ", 7 / -2);`,
+		`let f = fn(x){
+			let e = try { throw x; } catch (err) { err };
+			let g = fn(){ e.message + "!" };
+			try { 10 / 0 } catch (err) { println(err.kind, " ", err.message, " ", g(), " ", e) };
+			throw e;
+		};
+		try { f("boom") } catch (e) { println("outer: ", e.message) };
+		println(try { f(1) } catch (e) { e.message } finally { println("done") });
+		let deep = fn(n){ if (n == 0) { return 0; } 1 + deep(n - 1) };
+		println(deep(100));
+		println(try { deep(20000) } catch (e) { e.kind });`,
+		`let add = fn(a, b){ a + b };
+		println(add(1, 2, 3));
+		println(try { add(1) } catch (e) { e.message });
+		println(try { 1(2) } catch (e) { e.message });
+		println(-true);`,
+	}, programs...)
+	dir := t.TempDir()
+	for i, input := range inputs {
+		program, expected := interpret(input)
+		src, err := GenerateC(program)
+		if err != nil {
+			t.Fatalf("inputs[%d]: %s", i, err)
+		}
+		file := filepath.Join(dir, "main.c")
+		if err := os.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		binary := filepath.Join(dir, "prog")
+		if err := ccBuild(file, binary); err != nil {
+			t.Fatalf("inputs[%d]: %s\n%s", i, err, src)
+		}
+		var got bytes.Buffer
+		run := exec.Command(binary)
+		run.Stdout = &got
+		run.Stderr = &got
+		_ = run.Run()
+		if got.String() != expected {
+			t.Errorf("inputs[%d]: output mismatch.\nexpected:\n%s\ngot:\n%s", i, expected, got.String())
+		}
+	}
+}
+
+func TestBuildC(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not available")
+	}
+	program, _ := interpret(`let sq = fn(x){ x * x }; println("sq(7)=", sq(7));`)
+	dir := t.TempDir()
+	emit := filepath.Join(dir, "sq.c")
+	binary, err := Build(program, "sq.tl", Options{Output: filepath.Join(dir, "sq"), Target: TargetC, EmitC: emit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(binary).Output()
+	if err != nil || string(out) != "sq(7)=49\n" {
+		t.Errorf("running %s: %v, output %q", binary, err, out)
+	}
+	if src, err := os.ReadFile(emit); err != nil || !bytes.Contains(src, []byte("tl_main")) {
+		t.Errorf("generated C source not kept: %v", err)
+	}
+	if _, err := Build(program, "sq.tl", Options{Target: TargetC, GOOS: "linux"}); err == nil {
+		t.Errorf("expected an error when combining the C target with GOOS")
+	}
+}
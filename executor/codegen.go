@@ -0,0 +1,429 @@
+package executor
+
+import (
+	"TLanguage/ast"
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+// 函数内 return 语句的生成方式
+const (
+	returnFunc = iota //普通函数：return v
+	returnTry         //try/catch/finally 生成的闭包：return v, true
+)
+
+// T的块作用域，对应生成代码中的一个Go块
+type scope struct {
+	names   map[string]string //T变量名 -> Go变量名
+	hoisted map[string]bool   //在块开头提前声明的变量，读取时需检查是否已赋值
+	outer   *scope
+}
+
+type generator struct {
+	out     bytes.Buffer
+	temps   int            //临时变量计数
+	used    map[string]int //每个T变量名已使用的次数，用于生成唯一的Go变量名
+	scope   *scope
+	returns []int //当前所在函数的 return 生成方式
+}
+
+// Generate 将T程序翻译为等价的Go程序源码
+func Generate(program *ast.Program) ([]byte, error) {
+	g := &generator{used: make(map[string]int)}
+	g.line("package main")
+	g.line("")
+	g.line("import (")
+	g.line(`"fmt"`)
+	g.line(`"os"`)
+	g.line(`"strconv"`)
+	g.line(`"strings"`)
+	g.line(")")
+	g.out.WriteString(runtimeSource)
+	g.line("")
+	g.line("func tlMain() Value {")
+	g.returns = append(g.returns, returnFunc)
+	g.block(program.Statements, "", false)
+	g.line("return nil")
+	g.line("}")
+	src, err := format.Source(g.out.Bytes())
+	if err != nil {
+		return g.out.Bytes(), fmt.Errorf("generated invalid Go source: %s", err)
+	}
+	return src, nil
+}
+
+func (g *generator) line(format string, a ...interface{}) {
+	fmt.Fprintf(&g.out, format, a...)
+	g.out.WriteString("\n")
+}
+
+func (g *generator) newTemp(prefix string) string {
+	g.temps++
+	return fmt.Sprintf("%s%d", prefix, g.temps)
+}
+
+// 将表达式的值保存到临时变量中，返回变量名
+func (g *generator) temp(value string) string {
+	t := g.newTemp("t")
+	g.line("var %s Value = %s", t, value)
+	return t
+}
+
+func (g *generator) declare(name string) string {
+	n := g.used[name]
+	g.used[name]++
+	goName := "v_" + name
+	if n > 0 {
+		goName = fmt.Sprintf("v%d_%s", n, name)
+	}
+	g.scope.names[name] = goName
+	return goName
+}
+
+func (g *generator) lookup(name string) (string, bool, bool) {
+	for s := g.scope; s != nil; s = s.outer {
+		if goName, ok := s.names[name]; ok {
+			return goName, s.hoisted[name], true
+		}
+	}
+	return "", false, false
+}
+
+// 进入新的块作用域。块中被内部函数引用的 let 变量需提前声明，
+// 这样函数可以引用在其后才定义的变量(例如互相递归的函数)
+func (g *generator) pushScope(stmts []ast.Statement) {
+	g.scope = &scope{names: make(map[string]string), hoisted: make(map[string]bool), outer: g.scope}
+	captured := make(map[string]bool)
+	for _, s := range stmts {
+		ast.Walk(s, func(n ast.Node) bool {
+			if fn, ok := n.(*ast.FunctionLiteral); ok {
+				ast.Walk(fn.Body, func(n ast.Node) bool {
+					if ident, ok := n.(*ast.Identifier); ok {
+						captured[ident.Value] = true
+					}
+					return true
+				})
+				return false
+			}
+			return true
+		})
+	}
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !captured[let.Name.Value] || g.scope.hoisted[let.Name.Value] {
+			continue
+		}
+		goName := g.declare(let.Name.Value)
+		g.scope.hoisted[let.Name.Value] = true
+		g.line("var %s Value", goName)
+		g.line("_ = %s", goName)
+	}
+}
+
+func (g *generator) popScope() {
+	g.scope = g.scope.outer
+}
+
+// 生成一个块，result 不为空时将块的值(最后一条语句的值)赋给 result。
+// tail 为 true 时块的最后一条语句处于函数体的尾位置
+func (g *generator) block(stmts []ast.Statement, result string, tail bool) {
+	g.line("{")
+	g.pushScope(stmts)
+	for i, s := range stmts {
+		switch {
+		case i < len(stmts)-1:
+			g.statement(s, "")
+		case tail:
+			g.tailStatement(s, result)
+		default:
+			g.statement(s, result)
+		}
+	}
+	g.popScope()
+	g.line("}")
+}
+
+func (g *generator) returnStatement(value string) {
+	if g.returns[len(g.returns)-1] == returnTry {
+		g.line("return %s, true", value)
+	} else {
+		g.line("return %s", value)
+	}
+}
+
+func (g *generator) statement(stmt ast.Statement, result string) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		name := s.Name.Value
+		if goName, ok := g.scope.names[name]; ok {
+			g.line("%s = %s", goName, g.expr(s.Value))
+		} else if fn, ok := s.Value.(*ast.FunctionLiteral); ok {
+			//先声明再赋值，函数体才能递归引用自身
+			goName := g.declare(name)
+			g.line("var %s Value", goName)
+			g.line("_ = %s", goName)
+			g.funcLit(fn, name, goName)
+		} else {
+			value := g.expr(s.Value)
+			goName := g.declare(name)
+			g.line("var %s Value = %s", goName, value)
+			g.line("_ = %s", goName)
+		}
+		if result != "" {
+			g.line("%s = nil", result)
+		}
+	case *ast.AssignStatement:
+		value := g.expr(s.Value)
+		if goName, _, ok := g.lookup(s.Name.Value); ok {
+			g.line("%s = %s", goName, value)
+		} else {
+			g.line("_ = %s", value)
+			g.line("rtFail(%q, %q)", "unknown identier:%v", s.Name.Value)
+		}
+		if result != "" {
+			g.line("%s = nil", result)
+		}
+	case *ast.MemberAssignStatement:
+		obj := g.expr(s.Target.Object)
+		if hasPrelude(s.Value) {
+			obj = g.temp(obj)
+		}
+		g.line("rtSetMember(%s, %q, %s)", obj, s.Target.Property.Value, g.expr(s.Value))
+		if result != "" {
+			g.line("%s = nil", result)
+		}
+	case *ast.ReturnStatement:
+		//函数内 try 之外的 return f(...) 是尾调用
+		call, ok := s.ReturnValue.(*ast.CallExpression)
+		if ok && len(g.returns) > 1 && g.returns[len(g.returns)-1] == returnFunc {
+			g.returnStatement(g.tailCall(call))
+		} else {
+			g.returnStatement(g.expr(s.ReturnValue))
+		}
+	case *ast.ThrowStatement:
+		g.line("panic(rtThrow(%s))", g.expr(s.Value))
+	case *ast.ExpressionStatement:
+		switch e := s.Expression.(type) {
+		case *ast.IfExpression:
+			g.ifStatement(e, result, false)
+		case *ast.WhileExpression:
+			g.whileStatement(e)
+			if result != "" {
+				g.line("%s = null", result)
+			}
+		case *ast.TryExpression:
+			g.tryStatement(e, result)
+		default:
+			value := g.expr(e)
+			if result != "" {
+				g.line("%s = %s", result, value)
+			} else {
+				g.line("_ = %s", value)
+			}
+		}
+	}
+}
+
+// 与解释器的规则相同：函数体最后的调用表达式以及 if 所选分支中最后的调用为尾调用，
+// 生成 rtTail 而不是 rtCall，递归不受调用深度的限制
+func (g *generator) tailStatement(stmt ast.Statement, result string) {
+	if s, ok := stmt.(*ast.ExpressionStatement); ok {
+		switch e := s.Expression.(type) {
+		case *ast.CallExpression:
+			g.line("%s = %s", result, g.tailCall(e))
+			return
+		case *ast.IfExpression:
+			g.ifStatement(e, result, true)
+			return
+		}
+	}
+	g.statement(stmt, result)
+}
+
+func (g *generator) tailCall(e *ast.CallExpression) string {
+	values := g.exprs(append([]ast.Expression{e.Function}, e.Arguments...))
+	return fmt.Sprintf("rtTail(%s)", strings.Join(values, ", "))
+}
+
+// 表达式中含有需要先生成语句的节点(if、while、try)
+func hasPrelude(e ast.Expression) bool {
+	found := false
+	ast.Walk(e, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.IfExpression, *ast.WhileExpression, *ast.TryExpression:
+			found = true
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// 按顺序求值一组表达式。若后面的表达式需要先生成语句，前面的值先保存到临时变量中以保持求值顺序
+func (g *generator) exprs(list []ast.Expression) []string {
+	values := make([]string, len(list))
+	for i, e := range list {
+		values[i] = g.expr(e)
+		for _, rest := range list[i+1:] {
+			if hasPrelude(rest) {
+				values[i] = g.temp(values[i])
+				break
+			}
+		}
+	}
+	return values
+}
+
+func (g *generator) expr(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return fmt.Sprintf("int64(%d)", e.Value)
+	case *ast.StringLiteral:
+		return "&rtString{value: " + strconv.Quote(e.Value) + "}"
+	case *ast.Boolean:
+		return strconv.FormatBool(e.Value)
+	case *ast.Identifier:
+		goName, hoisted, ok := g.lookup(e.Value)
+		if !ok {
+			return fmt.Sprintf("rtUndefined(%q)", e.Value)
+		}
+		if hoisted {
+			return fmt.Sprintf("rtGet(%s, %q)", goName, e.Value)
+		}
+		return goName
+	case *ast.PrefixExpression:
+		return fmt.Sprintf("rtPrefix(%q, %s)", e.Operator, g.expr(e.Right))
+	case *ast.InfixExpression:
+		values := g.exprs([]ast.Expression{e.Left, e.Right})
+		return fmt.Sprintf("rtInfix(%q, %s, %s)", e.Operator, values[0], values[1])
+	case *ast.CallExpression:
+		values := g.exprs(append([]ast.Expression{e.Function}, e.Arguments...))
+		return fmt.Sprintf("rtCall(%s)", strings.Join(values, ", "))
+	case *ast.PrintlnExpression:
+		return fmt.Sprintf("rtPrintln(%s)", strings.Join(g.exprs(e.Arguments), ", "))
+	case *ast.MemberExpression:
+		return fmt.Sprintf("rtMember(%s, %q)", g.expr(e.Object), e.Property.Value)
+	case *ast.FunctionLiteral:
+		t := g.newTemp("t")
+		g.line("var %s Value", t)
+		g.funcLit(e, "", t)
+		return t
+	case *ast.IfExpression:
+		t := g.newTemp("t")
+		g.line("var %s Value", t)
+		g.ifStatement(e, t, false)
+		return t
+	case *ast.WhileExpression:
+		g.whileStatement(e)
+		return "null"
+	case *ast.TryExpression:
+		t := g.newTemp("t")
+		g.line("var %s Value", t)
+		g.tryStatement(e, t)
+		return t
+	}
+	return "nil"
+}
+
+// 函数值的字符串表示，与 object.Function 的 Inspect 相同
+func inspectFunction(fn *ast.FunctionLiteral) string {
+	params := []string{}
+	for _, p := range fn.Parameters {
+		params = append(params, p.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + "){\n" + fn.Body.String() + "\n}"
+}
+
+// 生成函数字面量并赋值给 target
+func (g *generator) funcLit(fn *ast.FunctionLiteral, name string, target string) {
+	g.line("%s = &rtFunc{name: %q, inspect: %q, params: %d, fn: func(args []Value) Value {", target, name, inspectFunction(fn), len(fn.Parameters))
+	g.returns = append(g.returns, returnFunc)
+	g.scope = &scope{names: make(map[string]string), hoisted: make(map[string]bool), outer: g.scope}
+	for i, p := range fn.Parameters {
+		goName := g.declare(p.Value)
+		g.line("var %s Value = args[%d]", goName, i)
+		g.line("_ = %s", goName)
+	}
+	result := g.newTemp("r")
+	g.line("var %s Value", result)
+	g.block(fn.Body.Statements, result, true)
+	g.line("return %s", result)
+	g.popScope()
+	g.returns = g.returns[:len(g.returns)-1]
+	g.line("}}")
+}
+
+// tail 为 true 时 if 处于函数体的尾位置，所选分支的最后一条语句同样处于尾位置
+func (g *generator) ifStatement(e *ast.IfExpression, result string, tail bool) {
+	condition := g.expr(e.Condition)
+	g.line("if rtTruthy(%s) {", condition)
+	g.block(e.Consequence.Statements, result, tail)
+	switch {
+	case e.Another != nil:
+		g.line("} else {")
+		g.ifStatement(e.Another, result, tail)
+	case e.Alternative != nil:
+		g.line("} else {")
+		g.block(e.Alternative.Statements, result, tail)
+	case result != "":
+		g.line("} else {")
+		g.line("%s = null", result)
+	}
+	g.line("}")
+}
+
+func (g *generator) whileStatement(e *ast.WhileExpression) {
+	g.line("for {")
+	condition := g.expr(e.Condition)
+	g.line("if !rtTruthy(%s) {", condition)
+	g.line("break")
+	g.line("}")
+	g.block(e.Body.Statements, "", false)
+	g.line("}")
+}
+
+func (g *generator) tryStatement(e *ast.TryExpression, result string) {
+	value, returned := g.newTemp("t"), g.newTemp("t")
+	g.line("%s, %s := rtTry(func() (Value, bool) {", value, returned)
+	g.returns = append(g.returns, returnTry)
+	r := g.newTemp("r")
+	g.line("var %s Value", r)
+	g.block(e.Block.Statements, r, false)
+	g.line("return %s, false", r)
+	closing := "}"
+	if e.Catch != nil {
+		g.line("}, func(e Value) (Value, bool) {")
+		g.scope = &scope{names: make(map[string]string), hoisted: make(map[string]bool), outer: g.scope}
+		goName := g.declare(e.Param.Value)
+		g.line("var %s Value = e", goName)
+		g.line("_ = %s", goName)
+		r := g.newTemp("r")
+		g.line("var %s Value", r)
+		g.block(e.Catch.Statements, r, false)
+		g.line("return %s, false", r)
+		g.popScope()
+	} else {
+		closing += ", nil"
+	}
+	if e.Finally != nil {
+		g.line("%s, func() (Value, bool) {", closing)
+		g.block(e.Finally.Statements, "", false)
+		g.line("return nil, false")
+		g.line("})")
+	} else {
+		g.line("%s, nil)", closing)
+	}
+	g.returns = g.returns[:len(g.returns)-1]
+	g.line("if %s {", returned)
+	g.returnStatement(value)
+	g.line("}")
+	if result != "" {
+		g.line("%s = %s", result, value)
+	} else {
+		g.line("_ = %s", value)
+	}
+}
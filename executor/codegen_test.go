@@ -0,0 +1,262 @@
+package executor
+
+import (
+	"TLanguage/ast"
+	"TLanguage/evaluator"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"bytes"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 各后端共用的测试程序
+var programs = []string{
+	`let fab = fn(x){ if(x < 2){ return 1; } else { return fab(x-2)+fab(x-1); } }
+	println(fab(15));`,
+	`let a = 1;
+	while(a < 5){ println("a=", a); a = a + 1; }
+	let lessThan = fn(a, b){ return a < b; }
+	while(lessThan(a, 8)){ println("a=", a); a = a + 1; }`,
+	`let newAdder = fn(x){ fn(y){ x + y } };
+	let addTwo = newAdder(2);
+	println(addTwo(3), " ", newAdder(10)(5));
+	println(newAdder);`,
+	`let even = fn(n){ if (n == 0) { return true; } odd(n - 1) };
+	let odd = fn(n){ if (n == 0) { return false; } even(n - 1) };
+	println(even(10), odd(7), even(7));`,
+	`let x = 1;
+	let f = fn(){ x = x + 1; x };
+	f(); f();
+	println("x=", x, " f()=", f());
+	let x = "shadow";
+	println(x, " ", !x, " ", -5, " ", 1 == true, " ", "a" + "b");`,
+	`let v = if (1 > 2) { "yes" } else if (2 > 1) { "elif" } else { "no" };
+	println(v, if (false) { 1 });
+	println(10 / 3, " ", 2 * (3 + 4), " ", 1 < 2, 3 != 3);`,
+	`let check = fn(x){ if (x < 0) { throw "negative"; } return x; };
+	let r = try { check(-1) } catch (e) { println(e.kind, ": ", e.message); 0 } finally { println("finally"); };
+	println(r);
+	let f = fn(){ try { return 1; } finally { println("cleanup"); } };
+	println(f());
+	println(try { 1 + true } catch (e) { e.message });
+	try { missing } catch (e) { println(e.message) }`,
+	`let s = "100% \ done";
+	println(s, " %d");`,
+	`println("before");
+	let f = fn(){ 1 + "a" };
+	f();
+	println("after");`,
+	//顶层循环中的 let 每次迭代都是新的变量
+	`let a = fn(){ 0 }; let i = 0;
+	while (i < 2) { let j = i; if (i == 0) { a = fn(){ j }; } i = i + 1; }
+	println(a());`,
+	//字符串按对象比较，内容相同的两个字符串不相等
+	`let s = "a"; let t = s;
+	println("a" == "a", " ", "a" != "a", " ", s == s, " ", t == s, " ", s + "" == s);
+	let f = fn(x){ x };
+	println(f(s) == s, " ", try { throw "x" } catch (e) { e.message == e.message });`,
+	//尾调用不增加调用深度，递归次数超过深度限制
+	`let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+	let f = fn(n) { let s = "x"; if (n > 0) { f(n - 1) } else { try { g(s) } catch (e) { e.message } } };
+	let g = fn(s) { 1 + s };
+	println(sum(20000, 0), " ", f(3));`,
+	//return f(...) 同样是尾调用，try 中的 return 不是
+	`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+	let g = fn(n) { try { return n; } finally { println("finally"); } };
+	let h = fn(n) { if (n == 0) { return 0; } return g(n); };
+	println(sum(100000, 0), " ", h(2));`,
+	//不是尾调用的递归超过调用深度时所有后端都报告 RecursionError
+	`let f = fn(n) { 1 + f(n + 1) };
+	println(try { f(0) } catch (e) { e.message });
+	println(f(0));`,
+}
+
+// 解析并用解释器执行程序，返回语法树与解释器的输出(包括未捕获的错误)
+func interpret(input string) (*ast.Program, string) {
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	var expected bytes.Buffer
+	result := evaluator.New(&expected, &expected).Eval(program, object.NewEnvironment())
+	if err, ok := result.(*object.Error); ok {
+		expected.WriteString(err.Kind + ": " + err.Message + "\n")
+	}
+	return program, expected.String()
+}
+
+// 生成的程序应与解释器的输出一致
+func TestGeneratedProgramMatchesInterpreter(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	dir := t.TempDir()
+	for i, input := range programs {
+		program, expected := interpret(input)
+
+		src, err := Generate(program)
+		if err != nil {
+			t.Fatalf("inputs[%d]: %s\n%s", i, err, src)
+		}
+		file := filepath.Join(dir, "main.go")
+		if err := os.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		binary := filepath.Join(dir, "prog")
+		build := exec.Command("go", "build", "-o", binary, file)
+		build.Dir = dir
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("inputs[%d]: go build failed: %s\n%s\n%s", i, err, out, src)
+		}
+		var got bytes.Buffer
+		run := exec.Command(binary)
+		run.Stdout = &got
+		run.Stderr = &got
+		_ = run.Run()
+		if got.String() != expected {
+			t.Errorf("inputs[%d]: output mismatch.\nexpected:\n%s\ngot:\n%s", i, expected, got.String())
+		}
+	}
+}
+
+// 字符串中的引号、反斜杠、格式化动词等在生成的Go源码中应被正确转义
+func TestGenerateEscapesStrings(t *testing.T) {
+	values := []string{`100%`, `%s %d %%`, `a\b\\c\n`, "back`tick", "{}()", "中文"}
+	for _, value := range values {
+		input := `println("` + value + `");`
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		src, err := Generate(program)
+		if err != nil {
+			t.Fatalf("%q: %s\n%s", value, err, src)
+		}
+		if _, err := goparser.ParseFile(token.NewFileSet(), "main.go", src, 0); err != nil {
+			t.Fatalf("%q: generated source does not parse: %s", value, err)
+		}
+		if !bytes.Contains(src, []byte(strconv.Quote(value))) {
+			t.Errorf("%q: generated source does not contain the quoted literal %s", value, strconv.Quote(value))
+		}
+	}
+}
+
+func TestGoBuildReportsCompilerOutput(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	file := filepath.Join(t.TempDir(), "bad.go")
+	if err := os.WriteFile(file, []byte("package main\n\nfunc main() { undefinedName() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := goBuild(file, filepath.Join(t.TempDir(), "bad"), Options{})
+	if err == nil {
+		t.Fatal("expected build error")
+	}
+	if !strings.Contains(err.Error(), "undefined: undefinedName") {
+		t.Errorf("build error should contain the compiler output, got %q", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	program := parser.NewParser(lexer.NewLexer(`println("hello");`)).ParseProgram()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	//当前目录下同名的Go文件不应被覆盖
+	mine := []byte("package main // user file\n")
+	if err := os.WriteFile("hello.go", mine, 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary, err := Build(program, "hello.tl", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary != filepath.Join(dir, "hello") {
+		t.Errorf("wrong default output. got %s", binary)
+	}
+	if content, _ := os.ReadFile("hello.go"); !bytes.Equal(content, mine) {
+		t.Errorf("hello.go in the working directory was modified")
+	}
+	out, err := exec.Command(binary).Output()
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("running %s: %v, output %q", binary, err, out)
+	}
+
+	output := filepath.Join(dir, "bin", "prog")
+	if err := os.Mkdir(filepath.Dir(output), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(program, "hello.tl", Options{Output: output, EmitGo: "gen.go"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("output binary missing: %s", err)
+	}
+	if src, err := os.ReadFile("gen.go"); err != nil || !bytes.HasPrefix(src, []byte("package main")) {
+		t.Errorf("generated Go source not kept: %v", err)
+	}
+
+	if _, err := Build(program, "hello.tl", Options{Output: "hello.tl"}); err == nil {
+		t.Errorf("expected an error when the output would overwrite the source")
+	}
+
+	//交叉编译为 Windows 可执行文件
+	exe, err := Build(program, "hello.tl", Options{GOOS: "windows", GOARCH: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(exe) != "hello.exe" {
+		t.Errorf("windows output should have .exe suffix, got %s", exe)
+	}
+	if header, _ := os.ReadFile(exe); !bytes.HasPrefix(header, []byte("MZ")) {
+		t.Errorf("%s is not a Windows executable", exe)
+	}
+}
+
+// 用Go自带的 Node.js 启动脚本运行生成的 wasm 模块
+func TestBuildWasm(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not available")
+	}
+	goroot, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+	runner := filepath.Join(strings.TrimSpace(string(goroot)), "lib", "wasm", "go_js_wasm_exec")
+	if _, err := os.Stat(runner); err != nil {
+		t.Skip("go_js_wasm_exec not available")
+	}
+	program := parser.NewParser(lexer.NewLexer(`let sq = fn(x){ x * x }; println("sq(7)=", sq(7)); println("100% done");`)).ParseProgram()
+	dir := t.TempDir()
+	module, err := Build(program, "sq.tl", Options{Output: filepath.Join(dir, "sq.wasm"), Target: TargetWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wasm_exec.js")); err != nil {
+		t.Errorf("wasm_exec.js was not copied next to the module: %s", err)
+	}
+	out, err := exec.Command(runner, module).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running %s: %s\n%s", module, err, out)
+	}
+	if string(out) != "sq(7)=49\n100% done\n" {
+		t.Errorf("wrong output %q", out)
+	}
+
+	if _, err := Build(program, "sq.tl", Options{Target: TargetWasm, GOOS: "linux"}); err == nil {
+		t.Errorf("expected an error when combining a wasm target with GOOS")
+	}
+}
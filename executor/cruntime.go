@@ -0,0 +1,679 @@
+package executor
+
+// 生成的C程序所使用的运行时，与 evaluator 的求值规则保持一致。
+// T的值表示为带标签的 tl_value，字符串、闭包、闭包捕获的变量(tl_cell)和异常使用引用计数管理。
+// 约定：表达式产生的值归调用方所有，运行时函数会消耗(释放)传入的值。
+// 错误通过 longjmp 传递到最近的 try，跳过的栈帧中的值不再释放
+const cRuntimeSource = `#include <inttypes.h>
+#include <setjmp.h>
+#include <stdarg.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef enum { TL_UNDEF, TL_NULL, TL_INT, TL_BOOL, TL_STR, TL_FUNC, TL_EXC, TL_TAIL } tl_tag;
+
+typedef struct tl_str tl_str;
+typedef struct tl_closure tl_closure;
+typedef struct tl_cell tl_cell;
+typedef struct tl_exc tl_exc;
+
+/* TL_UNDEF 表示已提前声明但尚未执行 let 的变量，TL_TAIL 表示函数以尾调用结束 */
+typedef struct {
+	tl_tag tag;
+	union {
+		int64_t i;
+		int b;
+		tl_str *s;
+		tl_closure *f;
+		tl_exc *e;
+	} u;
+} tl_value;
+
+/* 函数体；try/catch/finally 生成的函数执行 return 时将 *returned 置为 1 */
+typedef tl_value (*tl_fn)(tl_closure *self, tl_value *args, int *returned);
+
+struct tl_str {
+	long rc;
+	size_t len;
+	char data[];
+};
+
+struct tl_closure {
+	long rc;
+	tl_fn fn;
+	const char *name;
+	const char *inspect;
+	int params;
+	int nfree;
+	tl_cell *free[];
+};
+
+/* 被内部函数捕获的变量 */
+struct tl_cell {
+	long rc;
+	tl_value v;
+};
+
+struct tl_exc {
+	long rc;
+	char *kind;
+	char *message;
+};
+
+enum { TL_ADD, TL_SUB, TL_MUL, TL_DIV, TL_LT, TL_GT, TL_EQ, TL_NE };
+static const char *tl_op_names[] = {"+", "-", "*", "/", "<", ">", "==", "!="};
+
+#define TL_MAX_DEPTH 10000
+
+typedef struct tl_handler {
+	jmp_buf buf;
+	struct tl_handler *prev;
+} tl_handler;
+
+static tl_handler *tl_handlers;
+static char *tl_error_kind, *tl_error_message;
+static int tl_depth;
+
+static void *tl_alloc(size_t n) {
+	void *p = malloc(n);
+	if (p == NULL) {
+		fflush(stdout);
+		fputs("out of memory\n", stderr);
+		exit(1);
+	}
+	return p;
+}
+
+static char *tl_strndup(const char *s, size_t n) {
+	char *p = tl_alloc(n + 1);
+	memcpy(p, s, n);
+	p[n] = 0;
+	return p;
+}
+
+static char *tl_strdup(const char *s) {
+	return tl_strndup(s, strlen(s));
+}
+
+/* 抛出错误，kind 与 message 的所有权转移给错误处理者 */
+static void tl_raise(char *kind, char *message) {
+	if (tl_handlers == NULL) {
+		fflush(stdout);
+		fprintf(stderr, "%s: %s\n", kind, message);
+		exit(1);
+	}
+	tl_error_kind = kind;
+	tl_error_message = message;
+	longjmp(tl_handlers->buf, 1);
+}
+
+static void tl_fail_kind(const char *kind, const char *format, ...) {
+	va_list ap;
+	int n;
+	char *message;
+	va_start(ap, format);
+	n = vsnprintf(NULL, 0, format, ap);
+	va_end(ap);
+	message = tl_alloc((size_t)n + 1);
+	va_start(ap, format);
+	vsnprintf(message, (size_t)n + 1, format, ap);
+	va_end(ap);
+	tl_raise(tl_strdup(kind), message);
+}
+
+#define tl_fail(...) tl_fail_kind("RuntimeError", __VA_ARGS__)
+
+static tl_value tl_undef(void) {
+	tl_value v;
+	v.tag = TL_UNDEF;
+	v.u.i = 0;
+	return v;
+}
+
+static tl_value tl_null(void) {
+	tl_value v;
+	v.tag = TL_NULL;
+	v.u.i = 0;
+	return v;
+}
+
+static tl_value tl_int(int64_t i) {
+	tl_value v;
+	v.tag = TL_INT;
+	v.u.i = i;
+	return v;
+}
+
+static tl_value tl_bool(int b) {
+	tl_value v;
+	v.tag = TL_BOOL;
+	v.u.b = b != 0;
+	return v;
+}
+
+/* 长度为 len、内容未初始化的字符串 */
+static tl_value tl_str_alloc(size_t len) {
+	tl_value v;
+	tl_str *s = tl_alloc(sizeof(tl_str) + len + 1);
+	s->rc = 1;
+	s->len = len;
+	s->data[len] = 0;
+	v.tag = TL_STR;
+	v.u.s = s;
+	return v;
+}
+
+/* 每次求值字符串字面量都得到新的字符串，与解释器按对象比较相等保持一致 */
+static tl_value tl_str_new(const char *data, size_t len) {
+	tl_value v = tl_str_alloc(len);
+	memcpy(v.u.s->data, data, len);
+	return v;
+}
+
+static tl_value tl_retain(tl_value v) {
+	switch (v.tag) {
+	case TL_STR:
+		v.u.s->rc++;
+		break;
+	case TL_FUNC:
+		v.u.f->rc++;
+		break;
+	case TL_EXC:
+		v.u.e->rc++;
+		break;
+	default:
+		break;
+	}
+	return v;
+}
+
+static void tl_cell_release(tl_cell *c);
+
+static void tl_release(tl_value v) {
+	int i;
+	switch (v.tag) {
+	case TL_STR:
+		if (--v.u.s->rc == 0) {
+			free(v.u.s);
+		}
+		break;
+	case TL_FUNC:
+		if (--v.u.f->rc == 0) {
+			for (i = 0; i < v.u.f->nfree; i++) {
+				tl_cell_release(v.u.f->free[i]);
+			}
+			free(v.u.f);
+		}
+		break;
+	case TL_EXC:
+		if (--v.u.e->rc == 0) {
+			free(v.u.e->kind);
+			free(v.u.e->message);
+			free(v.u.e);
+		}
+		break;
+	default:
+		break;
+	}
+}
+
+static tl_cell *tl_cell_new(tl_value v) {
+	tl_cell *c = tl_alloc(sizeof(tl_cell));
+	c->rc = 1;
+	c->v = v;
+	return c;
+}
+
+static void tl_cell_release(tl_cell *c) {
+	if (--c->rc == 0) {
+		tl_release(c->v);
+		free(c);
+	}
+}
+
+static tl_value tl_closure_new(tl_fn fn, const char *name, const char *inspect, int params, int nfree, tl_cell **cells) {
+	tl_value v;
+	int i;
+	tl_closure *c = tl_alloc(sizeof(tl_closure) + (size_t)nfree * sizeof(tl_cell *));
+	c->rc = 1;
+	c->fn = fn;
+	c->name = name;
+	c->inspect = inspect;
+	c->params = params;
+	c->nfree = nfree;
+	for (i = 0; i < nfree; i++) {
+		cells[i]->rc++;
+		c->free[i] = cells[i];
+	}
+	v.tag = TL_FUNC;
+	v.u.f = c;
+	return v;
+}
+
+static const char *tl_type_name(tl_value v) {
+	switch (v.tag) {
+	case TL_INT:
+		return "INTEGER";
+	case TL_BOOL:
+		return "BOOLEAN";
+	case TL_STR:
+		return "STRING";
+	case TL_FUNC:
+		return "FUNCTION";
+	case TL_EXC:
+		return "EXCEPTION";
+	default:
+		return "NULL";
+	}
+}
+
+/* 值的字符串表示，返回的字符串由调用方释放 */
+static char *tl_inspect(tl_value v) {
+	char buf[32];
+	char *s;
+	switch (v.tag) {
+	case TL_INT:
+		snprintf(buf, sizeof(buf), "%" PRId64, v.u.i);
+		return tl_strdup(buf);
+	case TL_BOOL:
+		return tl_strdup(v.u.b ? "true" : "false");
+	case TL_STR:
+		return tl_strndup(v.u.s->data, v.u.s->len);
+	case TL_FUNC:
+		return tl_strdup(v.u.f->inspect);
+	case TL_EXC:
+		s = tl_alloc(strlen(v.u.e->message) + 8);
+		strcpy(s, "ERROR: ");
+		strcat(s, v.u.e->message);
+		return s;
+	default:
+		return tl_strdup("null");
+	}
+}
+
+static int tl_truthy(tl_value v) {
+	switch (v.tag) {
+	case TL_UNDEF:
+	case TL_NULL:
+		return 0;
+	case TL_BOOL:
+		return v.u.b;
+	default:
+		return 1;
+	}
+}
+
+/* 判断条件是否成立并释放条件的值 */
+static int tl_test(tl_value v) {
+	int t = tl_truthy(v);
+	tl_release(v);
+	return t;
+}
+
+/* 脚本的命令行参数，以及内置函数收到的参数个数 */
+static int tl_script_argc, tl_nargs;
+static char **tl_script_argv;
+
+/* exit(n)：以 n 为退出状态结束程序，不执行 finally */
+static tl_value tl_builtin_exit(tl_closure *self, tl_value *args, int *returned) {
+	int64_t status = 0;
+	int i;
+	(void)self;
+	(void)returned;
+	if (tl_nargs > 0) {
+		if (args[0].tag != TL_INT) {
+			tl_fail("exit: argument must be INTEGER, got %s", tl_type_name(args[0]));
+		}
+		status = args[0].u.i;
+	}
+	for (i = 0; i < tl_nargs; i++) {
+		tl_release(args[i]);
+	}
+	/* 操作系统只保留退出状态的低8位 */
+	if (status < 0 || status > 255) {
+		tl_fail("exit: status %" PRId64 " out of range 0..255", status);
+	}
+	fflush(stdout);
+	exit((int)status);
+}
+
+/* args() 返回参数个数，args(i) 返回第 i 个参数 */
+static tl_value tl_builtin_args(tl_closure *self, tl_value *args, int *returned) {
+	int64_t index;
+	int i;
+	(void)self;
+	(void)returned;
+	if (tl_nargs == 0) {
+		return tl_int(tl_script_argc);
+	}
+	if (args[0].tag != TL_INT) {
+		tl_fail("args: index must be INTEGER, got %s", tl_type_name(args[0]));
+	}
+	index = args[0].u.i;
+	for (i = 0; i < tl_nargs; i++) {
+		tl_release(args[i]);
+	}
+	if (index < 0 || index >= tl_script_argc) {
+		tl_fail("args: index out of range: %" PRId64 " with %d arguments", index, tl_script_argc);
+	}
+	return tl_str_new(tl_script_argv[index], strlen(tl_script_argv[index]));
+}
+
+/* 程序中没有定义的 exit、args 为内置函数，内置函数的 params 为 -1 */
+static tl_value tl_undefined(const char *name) {
+	if (strcmp(name, "exit") == 0) {
+		return tl_closure_new(tl_builtin_exit, "exit", "builtin exit", -1, 0, NULL);
+	}
+	if (strcmp(name, "args") == 0) {
+		return tl_closure_new(tl_builtin_args, "args", "builtin args", -1, 0, NULL);
+	}
+	tl_fail("identifier not found: %s", name);
+	return tl_null();
+}
+
+/* 读取提前声明的变量，尚未定义时报错 */
+static tl_value tl_get(tl_value v, const char *name) {
+	if (v.tag == TL_UNDEF) {
+		tl_fail("identifier not found: %s", name);
+	}
+	return tl_retain(v);
+}
+
+static void tl_define(tl_value *slot, tl_value v) {
+	tl_release(*slot);
+	*slot = v;
+}
+
+static void tl_assign(tl_value *slot, tl_value v, const char *name) {
+	if (slot->tag == TL_UNDEF) {
+		tl_release(v);
+		tl_fail("unknown identier:%s", name);
+	}
+	tl_define(slot, v);
+}
+
+static void tl_assign_undefined(tl_value v, const char *name) {
+	tl_release(v);
+	tl_fail("unknown identier:%s", name);
+}
+
+static tl_value tl_not(tl_value v) {
+	return tl_bool(!tl_test(v));
+}
+
+static tl_value tl_minus(tl_value v) {
+	if (v.tag != TL_INT) {
+		tl_release(v);
+		tl_fail("unknown operator: -%s", tl_type_name(v));
+	}
+	return tl_int((int64_t)(0 - (uint64_t)v.u.i));
+}
+
+/* 整数按值比较，其余按对象比较 */
+static int tl_same(tl_value l, tl_value r) {
+	if (l.tag != r.tag) {
+		return 0;
+	}
+	switch (l.tag) {
+	case TL_NULL:
+		return 1;
+	case TL_BOOL:
+		return l.u.b == r.u.b;
+	case TL_STR:
+		return l.u.s == r.u.s;
+	case TL_FUNC:
+		return l.u.f == r.u.f;
+	case TL_EXC:
+		return l.u.e == r.u.e;
+	default:
+		return 0;
+	}
+}
+
+static tl_value tl_infix(int op, tl_value l, tl_value r) {
+	tl_value v;
+	int same;
+	if (l.tag == TL_INT && r.tag == TL_INT) {
+		int64_t a = l.u.i, b = r.u.i;
+		switch (op) {
+		case TL_ADD:
+			return tl_int((int64_t)((uint64_t)a + (uint64_t)b));
+		case TL_SUB:
+			return tl_int((int64_t)((uint64_t)a - (uint64_t)b));
+		case TL_MUL:
+			return tl_int((int64_t)((uint64_t)a * (uint64_t)b));
+		case TL_DIV:
+			if (b == 0) {
+				tl_fail("division by zero");
+			}
+			if (b == -1) {
+				return tl_int((int64_t)(0 - (uint64_t)a));
+			}
+			return tl_int(a / b);
+		case TL_LT:
+			return tl_bool(a < b);
+		case TL_GT:
+			return tl_bool(a > b);
+		case TL_EQ:
+			return tl_bool(a == b);
+		default:
+			return tl_bool(a != b);
+		}
+	}
+	if (op == TL_EQ || op == TL_NE) {
+		same = tl_same(l, r);
+		tl_release(l);
+		tl_release(r);
+		return tl_bool(op == TL_EQ ? same : !same);
+	}
+	if (strcmp(tl_type_name(l), tl_type_name(r)) != 0) {
+		tl_fail("type mismatch: %s %s %s", tl_type_name(l), tl_op_names[op], tl_type_name(r));
+	}
+	if (op == TL_ADD && l.tag == TL_STR) {
+		v = tl_str_alloc(l.u.s->len + r.u.s->len);
+		memcpy(v.u.s->data, l.u.s->data, l.u.s->len);
+		memcpy(v.u.s->data + l.u.s->len, r.u.s->data, r.u.s->len);
+		tl_release(l);
+		tl_release(r);
+		return v;
+	}
+	tl_fail("unknown operator: %s %s %s", tl_type_name(l), tl_op_names[op], tl_type_name(r));
+	return tl_null();
+}
+
+/* 尾调用的被调函数与参数，参数在被调函数开头即被取走 */
+static tl_value tl_tail_fn;
+static tl_value *tl_tail_args;
+static int tl_tail_argc, tl_tail_cap;
+
+/* 函数体最后的调用：记下被调函数与参数后返回 TL_TAIL，
+ * 由 tl_call 在释放调用者的局部变量之后执行，调用深度不变 */
+static tl_value tl_tail(tl_value f, int argc, tl_value *args) {
+	tl_value v;
+	if (argc > tl_tail_cap) {
+		free(tl_tail_args);
+		tl_tail_cap = argc * 2;
+		tl_tail_args = tl_alloc((size_t)tl_tail_cap * sizeof(tl_value));
+	}
+	if (argc > 0) {
+		memcpy(tl_tail_args, args, (size_t)argc * sizeof(tl_value));
+	}
+	tl_tail_fn = f;
+	tl_tail_argc = argc;
+	v.tag = TL_TAIL;
+	return v;
+}
+
+/* 检查被调函数与参数个数，多余的参数被忽略。内置函数接收全部参数，参数个数由 tl_nargs 给出 */
+static void tl_check_call(tl_value f, int argc, tl_value *args) {
+	int i;
+	if (f.tag != TL_FUNC) {
+		tl_fail("not a function: %s", tl_type_name(f));
+	}
+	if (argc < f.u.f->params) {
+		tl_fail("wrong number of arguments. got=%d, want=%d", argc, f.u.f->params);
+	}
+	for (i = f.u.f->params; f.u.f->params >= 0 && i < argc; i++) {
+		tl_release(args[i]);
+	}
+	tl_nargs = argc;
+}
+
+/* 调用函数，被调函数以尾调用结束时在同一调用深度上继续执行 */
+static tl_value tl_call(tl_value f, int argc, tl_value *args) {
+	tl_value result;
+	tl_check_call(f, argc, args);
+	if (tl_depth >= TL_MAX_DEPTH) {
+		tl_fail_kind("RecursionError", "maximum call depth exceeded: %d", TL_MAX_DEPTH);
+	}
+	tl_depth++;
+	result = f.u.f->fn(f.u.f, args, NULL);
+	tl_release(f);
+	while (result.tag == TL_TAIL) {
+		f = tl_tail_fn;
+		tl_check_call(f, tl_tail_argc, tl_tail_args);
+		result = f.u.f->fn(f.u.f, tl_tail_args, NULL);
+		tl_release(f);
+	}
+	tl_depth--;
+	return result;
+}
+
+static tl_value tl_println(int argc, tl_value *args) {
+	int i;
+	for (i = 0; i < argc; i++) {
+		switch (args[i].tag) {
+		case TL_INT:
+			printf("%" PRId64, args[i].u.i);
+			break;
+		case TL_STR:
+			fwrite(args[i].u.s->data, 1, args[i].u.s->len, stdout);
+			break;
+		default: {
+			char *s = tl_inspect(args[i]);
+			fputs(s, stdout);
+			free(s);
+		}
+		}
+		tl_release(args[i]);
+	}
+	putchar('\n');
+	return tl_null();
+}
+
+static tl_value tl_member(tl_value v, const char *name) {
+	tl_value m;
+	if (v.tag != TL_EXC) {
+		tl_fail("type error: %s has no member %s", tl_type_name(v), name);
+	}
+	if (strcmp(name, "message") == 0) {
+		m = tl_str_new(v.u.e->message, strlen(v.u.e->message));
+	} else if (strcmp(name, "kind") == 0) {
+		m = tl_str_new(v.u.e->kind, strlen(v.u.e->kind));
+	} else if (strcmp(name, "stack") == 0) {
+		m = tl_str_new("", 0);
+	} else if (strcmp(name, "line") == 0 || strcmp(name, "column") == 0) {
+		m = tl_int(0);
+	} else {
+		tl_fail("type error: ERROR has no member %s", name);
+		return tl_null();
+	}
+	tl_release(v);
+	return m;
+}
+
+static void tl_set_member(tl_value obj, const char *name, tl_value v) {
+	tl_release(v);
+	tl_fail("type error: cannot assign member %s of %s", name, tl_type_name(obj));
+}
+
+static void tl_throw(tl_value v) {
+	char *kind, *message;
+	if (v.tag == TL_EXC) {
+		kind = tl_strdup(v.u.e->kind);
+		message = tl_strdup(v.u.e->message);
+	} else {
+		kind = tl_strdup("Error");
+		message = tl_inspect(v);
+	}
+	tl_release(v);
+	tl_raise(kind, message);
+}
+
+/* 在错误处理器的保护下执行 try 的一部分，出错时返回 0，错误保存在 tl_error_kind/tl_error_message 中 */
+static int tl_protect(tl_closure *part, tl_value *arg, tl_value *result, int *returned) {
+	tl_handler h;
+	int depth = tl_depth;
+	h.prev = tl_handlers;
+	tl_handlers = &h;
+	if (setjmp(h.buf) != 0) {
+		tl_handlers = h.prev;
+		tl_depth = depth;
+		return 0;
+	}
+	*result = part->fn(part, arg, returned);
+	tl_handlers = h.prev;
+	return 1;
+}
+
+/* 执行 try/catch/finally，catch_ 和 finally 为 TL_NULL 时表示没有该部分，
+   *returned 表示其中是否执行了 return */
+static tl_value tl_try(tl_value block, tl_value catch_, tl_value finally, int *returned) {
+	tl_value result = tl_null(), v;
+	char *kind = NULL, *message = NULL;
+	int ret = 0;
+	if (!tl_protect(block.u.f, NULL, &result, &ret)) {
+		kind = tl_error_kind;
+		message = tl_error_message;
+		if (catch_.tag == TL_FUNC) {
+			tl_exc *e = tl_alloc(sizeof(tl_exc));
+			e->rc = 1;
+			e->kind = kind;
+			e->message = message;
+			kind = message = NULL;
+			v.tag = TL_EXC;
+			v.u.e = e;
+			ret = 0;
+			if (!tl_protect(catch_.u.f, &v, &result, &ret)) {
+				kind = tl_error_kind;
+				message = tl_error_message;
+			}
+		}
+	}
+	if (finally.tag == TL_FUNC) {
+		int fret = 0;
+		v = finally.u.f->fn(finally.u.f, NULL, &fret);
+		if (fret) {
+			tl_release(result);
+			free(kind);
+			free(message);
+			result = v;
+			kind = message = NULL;
+			ret = 1;
+		} else {
+			tl_release(v);
+		}
+	}
+	tl_release(block);
+	tl_release(catch_);
+	tl_release(finally);
+	if (kind != NULL) {
+		tl_raise(kind, message);
+	}
+	*returned = ret;
+	return result;
+}
+`
+
+// 生成的C程序的入口
+const cMainSource = `
+int main(int argc, char **argv) {
+	int returned = 0;
+	tl_script_argc = argc - 1;
+	tl_script_argv = argv + 1;
+	tl_release(tl_main(NULL, NULL, &returned));
+	return 0;
+}
+`
@@ -1,6 +1,8 @@
 package executor
 
 import (
+	"TLanguage/ast"
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,40 +11,202 @@ import (
 	"strings"
 )
 
-var basic string = `package main 
-import "fmt"
+// 构建目标
+const (
+	TargetNative = ""       //本机或 GOOS/GOARCH 指定的平台
+	TargetWasm   = "wasm"   //GOOS=js GOARCH=wasm，在浏览器或 Node.js 中借助 wasm_exec.js 运行，println 输出到控制台
+	TargetWasip1 = "wasip1" //GOOS=wasip1 GOARCH=wasm，在 WASI 运行时中运行，println 输出到标准输出
+	TargetC      = "c"      //生成C99源码并用系统的C编译器(环境变量 CC，默认为 cc)编译为本机可执行文件，不需要Go工具链
+)
+
+// 构建选项，字段为零值时使用默认值
+type Options struct {
+	Output string //可执行文件路径，默认为当前目录下与源文件同名(去掉扩展名)的文件
+	EmitGo string //非空时将生成的Go源码保存到该路径
+	EmitC  string //Target 为 TargetC 时，非空则将生成的C源码保存到该路径
+	GOOS   string //目标操作系统，默认为本机
+	GOARCH string //目标架构，默认为本机
+	Target string //TargetWasm 或 TargetWasip1 时生成 .wasm 模块，TargetC 时经由C编译，均不能与 GOOS/GOARCH 同时使用
+}
+
+// 按 Target 确定目标平台
+func (opts Options) platform() (goos, goarch string, err error) {
+	switch opts.Target {
+	case TargetNative:
+		return opts.GOOS, opts.GOARCH, nil
+	case TargetWasm, TargetWasip1, TargetC:
+		if opts.GOOS != "" || opts.GOARCH != "" {
+			return "", "", fmt.Errorf("target %s cannot be combined with GOOS/GOARCH", opts.Target)
+		}
+		switch opts.Target {
+		case TargetWasm:
+			return "js", "wasm", nil
+		case TargetWasip1:
+			return "wasip1", "wasm", nil
+		}
+		return "", "", nil
+	}
+	return "", "", fmt.Errorf("unknown target %q, expected %s, %s or %s", opts.Target, TargetWasm, TargetWasip1, TargetC)
+}
+
+// Build 将T程序翻译为Go源码并编译为可执行文件，返回可执行文件的路径。
+// path 为T源文件路径，中间文件放在临时目录中，不会改动当前目录下的其他文件
+func Build(program *ast.Program, path string, opts Options) (string, error) {
+	goos, goarch, err := opts.platform()
+	if err != nil {
+		return "", err
+	}
+	opts.GOOS, opts.GOARCH = goos, goarch
+	if opts.Target == TargetC {
+		return buildC(program, path, opts)
+	}
+	src, err := Generate(program)
+	if err != nil {
+		return "", fmt.Errorf("generating Go source: %w", err)
+	}
+	if opts.EmitGo != "" {
+		if err := os.WriteFile(opts.EmitGo, src, 0644); err != nil {
+			return "", err
+		}
+	}
+	output, err := outputPath(path, opts)
+	if err != nil {
+		return "", err
+	}
 
-func main() {
-	fmt.Println("%s")
+	dir, err := os.MkdirTemp("", "tlc-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, src, 0644); err != nil {
+		return "", err
+	}
+	if err := goBuild(file, output, opts); err != nil {
+		return "", err
+	}
+	if opts.GOOS == "js" {
+		if err := copyWasmExec(filepath.Dir(output)); err != nil {
+			return "", err
+		}
+	}
+	return output, nil
 }
-`
 
-func Exec(out []string, path string) {
-	//创建临时文件
-	wd, _ := os.Getwd()
-	filename := filepath.Base(path)
-	ext := filepath.Ext(path)
-	name := filename[:len(filename)-len(ext)]
-	c := "\\"
-	if runtime.GOOS == "linux" {
-		c = "/"
+// 将T程序翻译为C源码并用系统的C编译器编译
+func buildC(program *ast.Program, path string, opts Options) (string, error) {
+	src, err := GenerateC(program)
+	if err != nil {
+		return "", fmt.Errorf("generating C source: %w", err)
+	}
+	if opts.EmitC != "" {
+		if err := os.WriteFile(opts.EmitC, src, 0644); err != nil {
+			return "", err
+		}
 	}
-	file, err := os.Create(wd + c + name + ".go")
+	output, err := outputPath(path, opts)
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		os.Exit(1)
+		return "", err
 	}
-	defer os.Remove(file.Name())
-	_, err = file.WriteString(fmt.Sprintf(basic, strings.Join(out, "\\n")))
+
+	dir, err := os.MkdirTemp("", "tlc-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.c")
+	if err := os.WriteFile(file, src, 0644); err != nil {
+		return "", err
+	}
+	if err := ccBuild(file, output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// 用C编译器编译生成的C源文件，失败时错误中包含编译器输出的信息
+func ccBuild(file, output string) error {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		return fmt.Errorf("C compiler not found: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(cc, "-std=c99", "-O2", "-o", output, file)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v\n%s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// 将Go自带的 wasm_exec.js 复制到 .wasm 模块所在目录，供宿主页面加载
+func copyWasmExec(dir string) error {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
 	if err != nil {
-		fmt.Println("Error writing to file:", err)
-		os.Exit(1)
+		return err
+	}
+	goroot := strings.TrimSpace(string(out))
+	for _, sub := range []string{"lib", "misc"} {
+		src, err := os.ReadFile(filepath.Join(goroot, sub, "wasm", "wasm_exec.js"))
+		if err == nil {
+			return os.WriteFile(filepath.Join(dir, "wasm_exec.js"), src, 0644)
+		}
+	}
+	return fmt.Errorf("wasm_exec.js not found in %s", goroot)
+}
+
+// 可执行文件的绝对路径，不允许覆盖源文件
+func outputPath(path string, opts Options) (string, error) {
+	output := opts.Output
+	if output == "" {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		goos := opts.GOOS
+		if goos == "" {
+			goos = runtime.GOOS
+		}
+		switch {
+		case opts.GOARCH == "wasm":
+			name += ".wasm"
+		case goos == "windows":
+			name += ".exe"
+		}
+		output = name
 	}
-	cmd := exec.Command("go", "build", file.Name())
-	fmt.Println(file.Name())
-	err = cmd.Run()
+	output, err := filepath.Abs(output)
 	if err != nil {
-		fmt.Println("Error building executable:", err)
-		os.Exit(1)
+		return "", err
+	}
+	if source, err := filepath.Abs(path); err == nil && source == output {
+		return "", fmt.Errorf("output %s would overwrite the source file", output)
+	}
+	return output, nil
+}
+
+// 编译生成的Go源文件，失败时错误中包含编译器输出的信息
+func goBuild(file, output string, opts Options) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", "build", "-o", output, filepath.Base(file))
+	cmd.Dir = filepath.Dir(file)
+	cmd.Stderr = &stderr
+	cmd.Env = os.Environ()
+	if opts.GOOS != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+opts.GOOS)
+	}
+	if opts.GOARCH != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+opts.GOARCH)
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v\n%s", err, msg)
+		}
+		return err
 	}
+	return nil
 }
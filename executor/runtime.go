@@ -0,0 +1,338 @@
+package executor
+
+// 生成的Go程序所使用的运行时，与 evaluator 的求值规则保持一致。
+// T的值在Go中表示为：int64、bool、*rtString、*rtNull、*rtFunc、*rtException
+const runtimeSource = `
+type Value interface{}
+
+type rtNull struct{}
+
+var null Value = &rtNull{}
+
+// 字符串按对象比较相等，与解释器一致：每次求值字符串字面量都得到新的字符串
+type rtString struct {
+	value string
+}
+
+type rtFunc struct {
+	name    string
+	inspect string
+	params  int
+	fn      func(args []Value) Value
+}
+
+// 运行时错误，以panic的形式在Go栈上传递
+type rtError struct {
+	kind    string
+	message string
+}
+
+// catch 捕获到的错误
+type rtException struct {
+	err *rtError
+}
+
+func rtFail(format string, a ...interface{}) {
+	panic(&rtError{kind: "RuntimeError", message: fmt.Sprintf(format, a...)})
+}
+
+func rtThrow(v Value) *rtError {
+	switch v := v.(type) {
+	case *rtException:
+		return v.err
+	case *rtString:
+		return &rtError{kind: "Error", message: v.value}
+	default:
+		return &rtError{kind: "Error", message: rtInspect(v)}
+	}
+}
+
+func rtTypeName(v Value) string {
+	switch v.(type) {
+	case int64:
+		return "INTEGER"
+	case bool:
+		return "BOOLEAN"
+	case *rtString:
+		return "STRING"
+	case *rtFunc:
+		return "FUNCTION"
+	case *rtException:
+		return "EXCEPTION"
+	default:
+		return "NULL"
+	}
+}
+
+func rtInspect(v Value) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case *rtString:
+		return v.value
+	case *rtFunc:
+		return v.inspect
+	case *rtException:
+		return "ERROR: " + v.err.message
+	default:
+		return "null"
+	}
+}
+
+func rtTruthy(v Value) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case *rtNull:
+		return false
+	case nil:
+		return false
+	default:
+		return true
+	}
+}
+
+// 程序中没有定义的 exit、args 为内置函数，args 返回程序的命令行参数
+var rtScriptBuiltins = map[string]*rtFunc{
+	"exit": {name: "exit", inspect: "builtin exit", fn: func(args []Value) Value {
+		status := int64(0)
+		if len(args) > 0 {
+			n, ok := args[0].(int64)
+			if !ok {
+				rtFail("exit: argument must be INTEGER, got %s", rtTypeName(args[0]))
+			}
+			status = n
+		}
+		//操作系统只保留退出状态的低8位
+		if status < 0 || status > 255 {
+			rtFail("exit: status %d out of range 0..255", status)
+		}
+		os.Exit(int(status))
+		return null
+	}},
+	"args": {name: "args", inspect: "builtin args", fn: func(args []Value) Value {
+		argv := os.Args[1:]
+		if len(args) == 0 {
+			return int64(len(argv))
+		}
+		i, ok := args[0].(int64)
+		if !ok {
+			rtFail("args: index must be INTEGER, got %s", rtTypeName(args[0]))
+		}
+		if i < 0 || i >= int64(len(argv)) {
+			rtFail("args: index out of range: %d with %d arguments", i, len(argv))
+		}
+		return &rtString{value: argv[i]}
+	}},
+}
+
+func rtUndefined(name string) Value {
+	if builtin, ok := rtScriptBuiltins[name]; ok {
+		return builtin
+	}
+	rtFail("identifier not found: %s", name)
+	return nil
+}
+
+func rtGet(v Value, name string) Value {
+	if v == nil {
+		rtFail("identifier not found: %s", name)
+	}
+	return v
+}
+
+func rtPrefix(op string, right Value) Value {
+	switch op {
+	case "!":
+		return !rtTruthy(right)
+	case "-":
+		if i, ok := right.(int64); ok {
+			return -i
+		}
+		rtFail("unknown operator: -%s", rtTypeName(right))
+	default:
+		rtFail("unknown operator: %s %s", op, rtTypeName(right))
+	}
+	return nil
+}
+
+func rtInfix(op string, left, right Value) Value {
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if lok && rok {
+		switch op {
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			if r == 0 {
+				rtFail("division by zero")
+			}
+			return l / r
+		case "<":
+			return l < r
+		case ">":
+			return l > r
+		case "==":
+			return l == r
+		case "!=":
+			return l != r
+		}
+		rtFail("unknown operator: INTEGER %s INTEGER", op)
+	}
+	switch {
+	case op == "==":
+		return rtEqual(left, right)
+	case op == "!=":
+		return !rtEqual(left, right)
+	case rtTypeName(left) != rtTypeName(right):
+		rtFail("type mismatch: %s %s %s", rtTypeName(left), op, rtTypeName(right))
+	case op == "+" && rtTypeName(left) == "STRING":
+		return &rtString{value: left.(*rtString).value + right.(*rtString).value}
+	}
+	rtFail("unknown operator: %s %s %s", rtTypeName(left), op, rtTypeName(right))
+	return nil
+}
+
+func rtEqual(left, right Value) bool {
+	if _, ok := left.(*rtNull); ok {
+		_, ok := right.(*rtNull)
+		return ok
+	}
+	return left == right
+}
+
+// 调用深度的上限，与解释器和C运行时相同
+const rtMaxDepth = 10000
+
+var rtDepth int
+
+// 函数体最后的调用：由 rtCall 在调用者返回之后执行，调用深度不变
+type rtTailCall struct {
+	fn   Value
+	args []Value
+}
+
+func rtTail(f Value, args ...Value) Value {
+	return &rtTailCall{fn: f, args: args}
+}
+
+// 检查被调函数与参数个数
+func rtCheckCall(f Value, args []Value) *rtFunc {
+	fn, ok := f.(*rtFunc)
+	if !ok {
+		rtFail("not a function: %s", rtTypeName(f))
+	}
+	if len(args) < fn.params {
+		rtFail("wrong number of arguments. got=%d, want=%d", len(args), fn.params)
+	}
+	return fn
+}
+
+// 调用函数，被调函数以尾调用结束时在同一调用深度上继续执行
+func rtCall(f Value, args ...Value) Value {
+	fn := rtCheckCall(f, args)
+	if rtDepth >= rtMaxDepth {
+		panic(&rtError{kind: "RecursionError", message: fmt.Sprintf("maximum call depth exceeded: %d", rtMaxDepth)})
+	}
+	rtDepth++
+	result := fn.fn(args)
+	for {
+		tail, ok := result.(*rtTailCall)
+		if !ok {
+			break
+		}
+		result = rtCheckCall(tail.fn, tail.args).fn(tail.args)
+	}
+	rtDepth--
+	return result
+}
+
+func rtPrintln(args ...Value) Value {
+	var out strings.Builder
+	for _, arg := range args {
+		out.WriteString(rtInspect(arg))
+	}
+	out.WriteString("\n")
+	_, _ = os.Stdout.WriteString(out.String())
+	return null
+}
+
+func rtMember(v Value, name string) Value {
+	ex, ok := v.(*rtException)
+	if !ok {
+		rtFail("type error: %s has no member %s", rtTypeName(v), name)
+	}
+	switch name {
+	case "message":
+		return &rtString{value: ex.err.message}
+	case "kind":
+		return &rtString{value: ex.err.kind}
+	case "stack":
+		return &rtString{}
+	case "line", "column":
+		return int64(0)
+	}
+	rtFail("type error: ERROR has no member %s", name)
+	return nil
+}
+
+func rtSetMember(v Value, name string, val Value) {
+	rtFail("type error: cannot assign member %s of %s", name, rtTypeName(v))
+}
+
+// 执行 try/catch/finally，返回值与是否执行了 return
+func rtTry(block func() (Value, bool), catch func(e Value) (Value, bool), finally func() (Value, bool)) (result Value, returned bool) {
+	var pending interface{}
+	//出错时跳过了 rtCall 中的 rtDepth--，恢复进入 try 时的调用深度
+	depth := rtDepth
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				pending = r
+				rtDepth = depth
+			}
+		}()
+		result, returned = block()
+	}()
+	if err, ok := pending.(*rtError); ok && catch != nil {
+		pending = nil
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					pending = r
+					rtDepth = depth
+				}
+			}()
+			result, returned = catch(&rtException{err: err})
+		}()
+	}
+	if finally != nil {
+		if v, ret := finally(); ret {
+			return v, true
+		}
+	}
+	if pending != nil {
+		panic(pending)
+	}
+	return result, returned
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*rtError); ok {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", err.kind, err.message)
+				os.Exit(1)
+			}
+			panic(r)
+		}
+	}()
+	tlMain()
+}
+`
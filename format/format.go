@@ -0,0 +1,442 @@
+// Package format 把 T 语言源码整理为统一的风格：四个空格缩进、运算符两侧各一个空格、
+// 语句以分号结尾、只保留必要的括号。注释原样保留，语句之间的空行最多保留一行。
+// 表达式内部的注释留在原来的位置，其后的内容另起一行接着输出
+package format
+
+import (
+	"TLanguage/ast"
+	"TLanguage/diag"
+	"TLanguage/lexer"
+	"TLanguage/parser"
+	"TLanguage/token"
+	"errors"
+	"math"
+	"strings"
+)
+
+const indent = "    "
+
+// Error 表示源码有语法错误，无法格式化
+type Error struct {
+	Diagnostics []*diag.Diagnostic
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Source 返回格式化后的源码。源码有语法错误时返回 *Error。
+// 对结果再次格式化得到的内容不变，结果解析得到的语法树与原来的相同
+func Source(src string) (string, error) {
+	program, comments, err := parse(src)
+	if err != nil {
+		return "", err
+	}
+	p := &printer{comments: comments}
+	p.program(program)
+	out := p.out.String()
+	//重新解析结果，确认格式化没有改变程序
+	formatted, _, err := parse(out)
+	if err != nil || dump(formatted) != dump(program) {
+		return "", errors.New("format: formatting would change the meaning of the program")
+	}
+	return out, nil
+}
+
+func parse(src string) (*ast.Program, []lexer.Comment, error) {
+	l := lexer.NewLexer(src)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, nil, &Error{Diagnostics: errs}
+	}
+	return program, l.Comments(), nil
+}
+
+// 不含位置信息的语法树，用于比较两棵语法树是否相同
+func dump(program *ast.Program) string {
+	var out strings.Builder
+	_ = ast.Fprint(&out, program)
+	return out.String()
+}
+
+// 运算符的优先级，与 parser 中的相同
+const (
+	_ int = iota
+	lowest
+	equals      //==
+	lessGreater //> or <
+	sum         //+
+	product     //*
+	prefix      //-X or !X
+	call        //myFunction(X)
+	member      //obj.field
+	primary     //字面量、标识符以及以 } 结尾的表达式
+)
+
+var precedences = map[string]int{
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+}
+
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return precedences[e.Operator]
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.CallExpression:
+		return call
+	case *ast.MemberExpression:
+		return member
+	}
+	return primary
+}
+
+type printer struct {
+	out        strings.Builder
+	depth      int //缩进层数
+	comments   []lexer.Comment
+	next       int  //下一条未输出的注释
+	lastLine   int  //最近输出的内容在源码中的行号
+	blockStart bool //刚输出 {，下一行之前不加空行
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+// 另起一行，输出源码第 line 行的内容。与上一行内容在源码中隔有空行时保留一个空行
+func (p *printer) newline(line int) {
+	if p.out.Len() > 0 {
+		if line > p.lastLine+1 && !p.blockStart {
+			p.write("\n")
+		}
+		p.write("\n")
+	}
+	p.blockStart = false
+	p.write(strings.Repeat(indent, p.depth))
+}
+
+// 输出位于 pos 之前、尚未输出的注释，每条独占一行
+func (p *printer) commentsBefore(pos token.Position) {
+	for p.next < len(p.comments) && before(p.comments[p.next].Pos, pos) {
+		c := p.comments[p.next]
+		p.newline(c.Pos.Line)
+		p.write(c.Text)
+		p.lastLine = c.Pos.Line
+		p.next++
+	}
+}
+
+// 源码第 line 行末尾、pos 之前的注释跟在当前行之后输出
+func (p *printer) trailingComment(line int, pos token.Position) bool {
+	if p.next < len(p.comments) {
+		if c := p.comments[p.next]; c.Pos.Line == line && before(c.Pos, pos) {
+			p.write(" " + c.Text)
+			p.next++
+			return true
+		}
+	}
+	return false
+}
+
+// 输出表达式内部、pos 之前的注释。与前面的内容在同一行的注释跟在其后，否则独占一行；
+// 注释之后另起一行，以 depth 层缩进接着输出表达式的其余部分
+func (p *printer) inlineComments(pos token.Position, depth int) {
+	for p.next < len(p.comments) && before(p.comments[p.next].Pos, pos) {
+		c := p.comments[p.next]
+		out := strings.TrimRight(p.out.String(), " ")
+		switch {
+		case strings.HasSuffix(out, "\n"):
+			out += strings.Repeat(indent, depth)
+		case c.Pos.Line > p.lastLine:
+			out += "\n" + strings.Repeat(indent, depth)
+		default:
+			out += " "
+		}
+		p.out.Reset()
+		p.write(out + c.Text + "\n" + strings.Repeat(indent, depth))
+		p.lastLine = c.Pos.Line
+		p.next++
+	}
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func (p *printer) program(program *ast.Program) {
+	p.statements(program.Statements, token.Position{Line: math.MaxInt, Column: 1})
+	if p.out.Len() > 0 {
+		p.write("\n")
+	}
+}
+
+// 逐行输出语句和它们之间的注释，end 为语句序列结束的位置
+func (p *printer) statements(stmts []ast.Statement, end token.Position) {
+	for i, s := range stmts {
+		var next ast.Statement
+		nextPos := end
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+			nextPos = start(next)
+		}
+		pos := start(s)
+		p.commentsBefore(pos)
+		p.newline(pos.Line)
+		p.lastLine = pos.Line
+		p.statement(s, next)
+		p.lastLine = endLine(s)
+		p.trailingComment(p.lastLine, nextPos)
+	}
+	p.commentsBefore(end)
+}
+
+// 输出一条语句，next 为同一块中的下一条语句
+func (p *printer) statement(s ast.Statement, next ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.write("let " + s.Name.Value + " = ")
+		p.expression(s.Value)
+		p.write(";")
+	case *ast.AssignStatement:
+		p.write(s.Name.Value + " = ")
+		p.expression(s.Value)
+		p.write(";")
+	case *ast.MemberAssignStatement:
+		p.expression(s.Target)
+		p.write(" = ")
+		p.expression(s.Value)
+		p.write(";")
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expression(s.ReturnValue)
+		p.write(";")
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value)
+		p.write(";")
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression)
+		//以 } 结尾的 if、while、try 语句不加分号，除非下一条语句会被解析为对它的调用或减法
+		if !endsWithBlock(s.Expression) || next != nil && continues(next) {
+			p.write(";")
+		}
+	case *ast.BlockStatement:
+		p.block(s)
+	}
+}
+
+func endsWithBlock(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.IfExpression, *ast.WhileExpression, *ast.TryExpression:
+		return true
+	}
+	return false
+}
+
+// 语句以 ( 或 - 开头时，与前一个表达式之间没有分号会被解析为调用或减法
+func continues(s ast.Statement) bool {
+	p := &printer{}
+	p.statement(s, nil)
+	text := p.out.String()
+	return strings.HasPrefix(text, "(") || strings.HasPrefix(text, "-")
+}
+
+// 输出代码块，没有语句和注释的块输出为 {}
+func (p *printer) block(b *ast.BlockStatement) {
+	p.write("{")
+	p.lastLine = b.Token.Line
+	first := b.Rbrace.Pos()
+	if len(b.Statements) > 0 {
+		first = start(b.Statements[0])
+	}
+	trailing := p.trailingComment(b.Token.Line, first)
+	empty := len(b.Statements) == 0 && (p.next == len(p.comments) || !before(p.comments[p.next].Pos, first))
+	if empty && !trailing {
+		p.write("}")
+		return
+	}
+	p.depth++
+	p.blockStart = true
+	p.statements(b.Statements, b.Rbrace.Pos())
+	p.depth--
+	p.write("\n" + strings.Repeat(indent, p.depth) + "}")
+	p.blockStart = false
+	p.lastLine = b.Rbrace.Line
+}
+
+func (p *printer) expression(e ast.Expression) {
+	//表达式的起始位置不晚于 e.Pos()，之前没有注释时不必求起始位置
+	if p.next < len(p.comments) && before(p.comments[p.next].Pos, e.Pos()) {
+		p.inlineComments(start(e), p.depth+1)
+	}
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+		p.lastLine = e.Token.Line
+	case *ast.IntegerLiteral:
+		p.write(e.Token.Literal)
+		p.lastLine = e.Token.Line
+	case *ast.Boolean:
+		p.write(e.Token.Literal)
+		p.lastLine = e.Token.Line
+	case *ast.StringLiteral:
+		p.write(`"` + e.Value + `"`)
+		p.lastLine = e.Token.Line + strings.Count(e.Value, "\n")
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.lastLine = e.Token.Line
+		p.operand(e.Right, precedence(e.Right) < prefix)
+	case *ast.InfixExpression:
+		//运算符左结合：左侧优先级更低、右侧优先级不高于当前运算符时需要括号
+		prec := precedences[e.Operator]
+		p.operand(e.Left, precedence(e.Left) < prec)
+		p.write(" " + e.Operator + " ")
+		p.lastLine = e.Token.Line
+		p.operand(e.Right, precedence(e.Right) <= prec)
+	case *ast.CallExpression:
+		p.operand(e.Function, precedence(e.Function) < call)
+		p.write("(")
+		p.lastLine = e.Token.Line
+		p.expressions(e.Arguments)
+		p.closeParen(e.Rparen)
+	case *ast.MemberExpression:
+		p.operand(e.Object, precedence(e.Object) < call)
+		p.write(".")
+		p.inlineComments(e.Property.Pos(), p.depth+1)
+		p.write(e.Property.Value)
+		p.lastLine = e.Property.Token.Line
+	case *ast.PrintlnExpression:
+		p.write("println(")
+		p.lastLine = e.Token.Line
+		p.expressions(e.Arguments)
+		p.closeParen(e.Rparen)
+	case *ast.FunctionLiteral:
+		p.write("fn(")
+		p.lastLine = e.Token.Line
+		for i, param := range e.Parameters {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.inlineComments(param.Pos(), p.depth+1)
+			p.write(param.Value)
+			p.lastLine = param.Token.Line
+		}
+		p.closeParen(e.Body.Token)
+		p.write(" ")
+		p.block(e.Body)
+	case *ast.IfExpression:
+		p.ifExpression(e)
+	case *ast.WhileExpression:
+		p.write("while (")
+		p.lastLine = e.Token.Line
+		p.expression(e.Condition)
+		p.closeParen(e.Body.Token)
+		p.write(" ")
+		p.block(e.Body)
+	case *ast.TryExpression:
+		p.write("try ")
+		p.block(e.Block)
+		if e.Catch != nil {
+			p.write(" catch (")
+			p.inlineComments(e.Param.Pos(), p.depth+1)
+			p.write(e.Param.Value)
+			p.lastLine = e.Param.Token.Line
+			p.closeParen(e.Catch.Token)
+			p.write(" ")
+			p.block(e.Catch)
+		}
+		if e.Finally != nil {
+			p.write(" finally ")
+			p.block(e.Finally)
+		}
+	}
+}
+
+func (p *printer) ifExpression(e *ast.IfExpression) {
+	p.write("if (")
+	p.lastLine = e.Token.Line
+	p.expression(e.Condition)
+	p.closeParen(e.Consequence.Token)
+	p.write(" ")
+	p.block(e.Consequence)
+	if e.Another != nil {
+		p.write(" else ")
+		p.ifExpression(e.Another)
+	} else if e.Alternative != nil {
+		p.write(" else ")
+		p.block(e.Alternative)
+	}
+}
+
+// 输出 )，next 为源码中 ) 或其后的词法单元，之前的注释留在 ) 之前
+func (p *printer) closeParen(next token.Token) {
+	p.inlineComments(next.Pos(), p.depth)
+	p.write(")")
+	p.lastLine = next.Line
+}
+
+// 输出子表达式，paren 为 true 时加上括号
+func (p *printer) operand(e ast.Expression, paren bool) {
+	if paren {
+		p.write("(")
+	}
+	p.expression(e)
+	if paren {
+		p.write(")")
+	}
+}
+
+func (p *printer) expressions(list []ast.Expression) {
+	for i, e := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(e)
+	}
+}
+
+// 语句或表达式在源码中的起始位置：其中最靠前的词法单元的位置
+func start(n ast.Node) token.Position {
+	pos := n.Pos()
+	ast.Walk(n, func(node ast.Node) bool {
+		if n := node.Pos(); n.Line > 0 && before(n, pos) {
+			pos = n
+		}
+		return true
+	})
+	return pos
+}
+
+// 语句在源码中的结束行：其中最靠后的词法单元所在的行
+func endLine(s ast.Statement) int {
+	line := 0
+	ast.Walk(s, func(node ast.Node) bool {
+		end := node.Pos().Line
+		switch n := node.(type) {
+		case *ast.StringLiteral:
+			end += strings.Count(n.Value, "\n")
+		case *ast.BlockStatement:
+			end = n.Rbrace.Line
+		case *ast.CallExpression:
+			end = n.Rparen.Line
+		case *ast.PrintlnExpression:
+			end = n.Rparen.Line
+		}
+		line = max(line, end)
+		return true
+	})
+	return line
+}
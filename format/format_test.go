@@ -0,0 +1,134 @@
+package format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"a - (b - c); (a - b) - c; a - b + c", "a - (b - c);\na - b - c;\na - b + c;\n"},
+		{"-(a + b); -(-a); (-a).b; (a + b)(c); f(x).y()", "-(a + b);\n--a;\n(-a).b;\n(a + b)(c);\nf(x).y();\n"},
+		{"a==(b<c);(a==b)<c", "a == b < c;\n(a == b) < c;\n"},
+		{"let s = \"a  //b\"; x = 010", "let s = \"a  //b\";\nx = 010;\n"},
+		{"obj.a.b=fn(x,y){x}", "obj.a.b = fn(x, y) {\n    x;\n};\n"},
+		{"let f = fn(){}", "let f = fn() {};\n"},
+		{
+			"if(x < 2){return 1;}else if(x>3){throw \"big\"}else{x}",
+			"if (x < 2) {\n    return 1;\n} else if (x > 3) {\n    throw \"big\";\n} else {\n    x;\n}\n",
+		},
+		{
+			"while(i<3){i=i+1;};try{f()}catch(e){println(e.message)}finally{done()}",
+			"while (i < 3) {\n    i = i + 1;\n}\ntry {\n    f();\n} catch (e) {\n    println(e.message);\n} finally {\n    done();\n}\n",
+		},
+		//下一条语句以 ( 或 - 开头时 } 之后的分号不能省略
+		{"if (a) { 1 }; (a + b)(2); while (b) { 2 }; -3", "if (a) {\n    1;\n};\n(a + b)(2);\nwhile (b) {\n    2;\n};\n-3;\n"},
+		{"if (a) { 1 }\n(2)", "if (a) {\n    1;\n}(2);\n"},
+		{
+			"// 开头\n\n\n\nlet a = 1; // 行尾\n// 前面\nlet b = fn() { // 块首\n\n    // 块中\n\n    a   // 表达式后\n\n    // 块尾\n}\n\n// 结尾",
+			"// 开头\n\nlet a = 1; // 行尾\n// 前面\nlet b = fn() { // 块首\n    // 块中\n\n    a; // 表达式后\n\n    // 块尾\n};\n\n// 结尾\n",
+		},
+		{"let f = fn() {\n  // 只有注释\n}", "let f = fn() {\n    // 只有注释\n};\n"},
+		{"let s = \"two\nlines\"; // c\nprintln(s)", "let s = \"two\nlines\"; // c\nprintln(s);\n"},
+		//表达式内部的注释留在原来的位置
+		{"f(1, // 参数\n  2)", "f(1, // 参数\n    2);\n"},
+		{"println(1, // first\n  2 // second\n);", "println(1, // first\n    2 // second\n);\n"},
+		{"if (true // cond\n) { 1 }", "if (true // cond\n) {\n    1;\n}\n"},
+		{"let x = 1 + // 加\n  // 再加\n  2; // 行尾", "let x = 1 + // 加\n    // 再加\n    2; // 行尾\n"},
+		{"let f = fn(a, // 第一个\n b) { a }", "let f = fn(a, // 第一个\n    b) {\n    a;\n};\n"},
+		{"println( // 开头\n  a. // 成员\n  b)", "println( // 开头\n    a. // 成员\n    b);\n"},
+		{"", ""},
+		{"\n\n// 只有注释\n", "// 只有注释\n"},
+	}
+	for _, tt := range tests {
+		got, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+		if again, err := Source(got); err != nil || again != got {
+			t.Errorf("%q: formatting is not idempotent, got\n%s", tt.input, again)
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("let x = ;\nlet y = 1")
+	var formatErr *Error
+	if !errors.As(err, &formatErr) || len(formatErr.Diagnostics) == 0 {
+		t.Fatalf("expected *Error with diagnostics, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "1:9:") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+// 仓库中的示例程序：格式化不改变语法树(由 Source 检查)，再次格式化结果不变
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "*.tl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(string(src))
+		var formatErr *Error
+		if errors.As(err, &formatErr) {
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if twice, err := Source(once); err != nil || twice != once {
+			t.Errorf("%s: formatting is not idempotent:\n%s\n%s", file, once, twice)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n15\n"
+	expected := `--- a/x.tl
++++ b/x.tl
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -11,5 +11,4 @@
+ 11
+ 12
+ 13
+-14
+-15
+\ No newline at end of file
++15
+`
+	if got := Diff("a/x.tl", "b/x.tl", a, b); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+	if got := Diff("a", "b", "", "x\n"); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("unexpected diff for an empty file:\n%s", got)
+	}
+	if got := Diff("a", "b", a, a); got != "" {
+		t.Errorf("expected no diff, got\n%s", got)
+	}
+}
@@ -0,0 +1,256 @@
+package gobind
+
+import (
+	"TLanguage/evaluator"
+	"TLanguage/object"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Host 包装一个Go值，导出字段可通过 obj.Field 读写，导出方法可通过 obj.Method(...) 调用
+type Host struct {
+	v reflect.Value
+}
+
+// Value 将Go值转换为T对象。基本类型转换为对应的T值，结构体指针等转换为 *Host
+func Value(v interface{}) object.Object {
+	return FromGo(reflect.ValueOf(v))
+}
+
+// Func 将Go函数包装为T可调用的内置函数，参数与返回值自动转换
+func Func(name string, fn interface{}) *object.Builtin {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic(fmt.Sprintf("gobind: %s is not a function: %T", name, fn))
+	}
+	return bindFunc(name, v)
+}
+
+func (h *Host) Type() object.ObjectType {
+	return object.HOST_OBJ
+}
+
+func (h *Host) Inspect() string {
+	return fmt.Sprintf("%+v", h.v.Interface())
+}
+
+// Interface 返回被包装的Go值
+func (h *Host) Interface() interface{} {
+	return h.v.Interface()
+}
+
+func (h *Host) GetMember(name string) object.Object {
+	if m := h.v.MethodByName(name); m.IsValid() {
+		return bindFunc(name, m)
+	}
+	st := h.v
+	for st.Kind() == reflect.Ptr {
+		if st.IsNil() {
+			return object.NewError("type error: member %s of nil %s", name, h.v.Type())
+		}
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return object.NewError("type error: %s has no member %s", h.v.Type(), name)
+	}
+	field, ok := st.Type().FieldByName(name)
+	if !ok || !field.IsExported() {
+		return object.NewError("type error: %s has no member %s", h.v.Type(), name)
+	}
+	fv := st.FieldByIndex(field.Index)
+	//可寻址的结构体字段以指针形式返回，以便 a.B.C = x 能修改原值
+	if fv.Kind() == reflect.Struct && fv.CanAddr() {
+		fv = fv.Addr()
+	}
+	return FromGo(fv)
+}
+
+func (h *Host) SetMember(name string, val object.Object) object.Object {
+	if h.v.Kind() != reflect.Ptr || h.v.IsNil() || h.v.Elem().Kind() != reflect.Struct {
+		return object.NewError("type error: cannot assign %s of %s, need non-nil struct pointer", name, h.v.Type())
+	}
+	st := h.v.Elem()
+	field, ok := st.Type().FieldByName(name)
+	if !ok || !field.IsExported() {
+		return object.NewError("type error: %s has no field %s", h.v.Type(), name)
+	}
+	fv := st.FieldByIndex(field.Index)
+	gv, err := ToGo(val, fv.Type())
+	if err != nil {
+		return object.NewError("type error: field %s: %s", name, err)
+	}
+	fv.Set(gv)
+	return nil
+}
+
+func bindFunc(name string, fn reflect.Value) *object.Builtin {
+	ft := fn.Type()
+	return &object.Builtin{
+		Name: name,
+		Fn: func(args ...object.Object) object.Object {
+			in, err := convertArgs(ft, args)
+			if err != nil {
+				return object.NewError("type error: %s: %s", name, err)
+			}
+			return convertResults(fn.Call(in))
+		},
+	}
+}
+
+func convertArgs(ft reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	n := ft.NumIn()
+	if ft.IsVariadic() {
+		if len(args) < n-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), n-1)
+		}
+	} else if len(args) != n {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), n)
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var t reflect.Type
+		if ft.IsVariadic() && i >= n-1 {
+			t = ft.In(n - 1).Elem()
+		} else {
+			t = ft.In(i)
+		}
+		v, err := ToGo(arg, t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+// 返回值规则：无返回值为null；最后一个返回值为error且非nil时返回错误对象
+func convertResults(out []reflect.Value) object.Object {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if !out[len(out)-1].IsNil() {
+			return object.NewError("%s", out[len(out)-1].Interface().(error).Error())
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return evaluator.NULL
+	case 1:
+		return FromGo(out[0])
+	default:
+		return object.NewError("type error: cannot return %d values to T", len(out))
+	}
+}
+
+// FromGo 将反射值转换为T对象
+func FromGo(v reflect.Value) object.Object {
+	if !v.IsValid() {
+		return evaluator.NULL
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > 1<<63-1 {
+			return object.NewError("type error: %d overflows INTEGER", u)
+		}
+		return &object.Integer{Value: int64(u)}
+	case reflect.String:
+		return &object.String{Value: v.String()}
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE
+		}
+		return evaluator.FALSE
+	case reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL
+		}
+		if v.Type() == errorType {
+			return object.NewError("%s", v.Interface().(error).Error())
+		}
+		return FromGo(v.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return evaluator.NULL
+		}
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL
+		}
+		return bindFunc(v.Type().String(), v)
+	}
+	if obj, ok := v.Interface().(object.Object); ok {
+		return obj
+	}
+	return &Host{v: v}
+}
+
+// ToGo 将T对象转换为类型为t的Go值，无法转换时返回错误
+func ToGo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() > 0 && reflect.TypeOf(obj).Implements(t) {
+		return reflect.ValueOf(obj), nil
+	}
+	switch obj := obj.(type) {
+	case *object.Integer:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(obj.Value) {
+				return v, fmt.Errorf("%d overflows %s", obj.Value, t)
+			}
+			v.SetInt(obj.Value)
+			return v, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			v := reflect.New(t).Elem()
+			if obj.Value < 0 || v.OverflowUint(uint64(obj.Value)) {
+				return v, fmt.Errorf("%d overflows %s", obj.Value, t)
+			}
+			v.SetUint(uint64(obj.Value))
+			return v, nil
+		case reflect.Float32, reflect.Float64:
+			v := reflect.New(t).Elem()
+			v.SetFloat(float64(obj.Value))
+			return v, nil
+		case reflect.Interface:
+			if t.NumMethod() == 0 {
+				return reflect.ValueOf(obj.Value), nil
+			}
+		}
+	case *object.String:
+		switch t.Kind() {
+		case reflect.String:
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		case reflect.Interface:
+			if t.NumMethod() == 0 {
+				return reflect.ValueOf(obj.Value), nil
+			}
+		}
+	case *object.Boolean:
+		switch t.Kind() {
+		case reflect.Bool:
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		case reflect.Interface:
+			if t.NumMethod() == 0 {
+				return reflect.ValueOf(obj.Value), nil
+			}
+		}
+	case *object.Null:
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	case *Host:
+		if obj.v.Type().AssignableTo(t) {
+			return obj.v, nil
+		}
+		//允许将结构体指针传给值接收的参数
+		if obj.v.Kind() == reflect.Ptr && !obj.v.IsNil() && obj.v.Elem().Type().AssignableTo(t) {
+			return obj.v.Elem(), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.v.Type(), t)
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
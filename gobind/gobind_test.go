@@ -0,0 +1,133 @@
+package gobind
+
+import (
+	"TLanguage/evaluator"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type Address struct {
+	City string
+}
+
+type User struct {
+	Name    string
+	Age     int
+	Admin   bool
+	Home    Address
+	private int
+}
+
+func (u *User) Greet(greeting string) string {
+	return greeting + ", " + u.Name
+}
+
+func (u *User) Birthday() {
+	u.Age++
+}
+
+func (u *User) Check(age uint8) (bool, error) {
+	if age == 0 {
+		return false, errors.New("age must be positive")
+	}
+	return u.Age >= int(age), nil
+}
+
+func testEval(t *testing.T, input string, bindings map[string]object.Object) object.Object {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	env := object.NewEnvironment()
+	for name, val := range bindings {
+		env.Set(name, val)
+	}
+	return evaluator.Eval(program, env)
+}
+
+func TestFieldAccess(t *testing.T) {
+	u := &User{Name: "alice", Age: 30, Home: Address{City: "Paris"}}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"user.Name", "alice"},
+		{"user.Age + 1", "31"},
+		{"user.Admin", "false"},
+		{"user.Home.City", "Paris"},
+		{`user.Name = "bob"; user.Name`, "bob"},
+		{`user.Home.City = "Rome"; user.Home.City`, "Rome"},
+		{"user.Greet(\"hi\")", "hi, bob"},
+		{"user.Birthday(); user.Age", "31"},
+		{"user.Check(18)", "true"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input, map[string]object.Object{"user": Value(u)})
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.expected, evaluated)
+		}
+	}
+	if u.Name != "bob" || u.Home.City != "Rome" || u.Age != 31 {
+		t.Errorf("Go value was not updated. Got %+v", u)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`user.Age = "old"`, "type error: field Age: cannot use STRING as int"},
+		{"user.Greet(1)", "type error: Greet: argument 1: cannot use INTEGER as string"},
+		{"user.Greet()", "type error: Greet: wrong number of arguments. got=0, want=1"},
+		{"user.Check(300)", "type error: Check: argument 1: 300 overflows uint8"},
+		{"user.Check(0)", "age must be positive"},
+		{"user.private", "type error: *gobind.User has no member private"},
+		{"user.Missing = 1", "type error: *gobind.User has no field Missing"},
+		{"let x = 1; x.Name", "type error: INTEGER has no member Name"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input, map[string]object.Object{"user": Value(&User{Name: "alice"})})
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. Got %T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q: wrong error message. expected %q, got %q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	bindings := map[string]object.Object{
+		"upper": Func("upper", strings.ToUpper),
+		"join": Func("join", func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		}),
+		"newUser": Func("newUser", func(name string) *User {
+			return &User{Name: name}
+		}),
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`upper("abc")`, "ABC"},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`join(",")`, ""},
+		{`let u = newUser("carol"); u.Greet("hello")`, "hello, carol"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input, bindings)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.expected, evaluated)
+		}
+	}
+}
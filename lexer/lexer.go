@@ -1,16 +1,41 @@
 package lexer
 
-import "TLanguage/token"
+import (
+	"TLanguage/diag"
+	"TLanguage/token"
+	"strings"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input        string
 	position     int  //所读取的当前字符位置
 	readPosition int  //所读取的当前字符的下一个位置
 	ch           byte //当前正在查看的字符
+	line         int  //当前字符所在行
+	column       int  //当前字符所在列
+	errors       []*diag.Diagnostic
+	comments     []Comment
+}
+
+// Comment 是源码中的一条 // 注释，Text 包含开头的 // 但不包含行尾的空白
+type Comment struct {
+	Pos  token.Position
+	Text string
+}
+
+// Comments 返回到目前为止读到的注释，按在源码中的顺序排列
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+// Errors 返回词法错误：无法识别的字符和未结束的字符串
+func (l *Lexer) Errors() []*diag.Diagnostic {
+	return l.errors
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -31,6 +56,11 @@ func (l *Lexer) readIdentifier() string {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -40,6 +70,11 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+// 当前字符的位置
+func (l *Lexer) pos() token.Position {
+	return token.Position{Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
@@ -48,9 +83,22 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// 跳过空白和注释，注释记录下来供格式化工具使用
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		l.readChar()
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			pos, position := l.pos(), l.position
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+			text := strings.TrimRight(l.input[position:l.position], " \t\r")
+			l.comments = append(l.comments, Comment{Pos: pos, Text: text})
+		default:
+			return
+		}
 	}
 }
 
@@ -77,6 +125,7 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	line, column := l.line, l.column //词法单元的起始位置
 
 	switch l.ch {
 	case '=':
@@ -117,6 +166,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.NewToken(token.RPAREN, l.ch)
 	case ',':
 		tok = token.NewToken(token.COMMA, l.ch)
+	case '.':
+		tok = token.NewToken(token.DOT, l.ch)
 	case '{':
 		tok = token.NewToken(token.LBRACE, l.ch)
 	case '}':
@@ -124,6 +175,14 @@ func (l *Lexer) NextToken() token.Token {
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+		if l.ch == 0 {
+			start := token.Position{Line: line, Column: column}
+			d := diag.Errorf(diag.CodeUnterminatedString, diag.Span{Start: start, End: token.Position{Line: line, Column: column + 1}},
+				"unterminated string literal")
+			d.Expected, d.Found = `"`, token.EOF
+			d.Fixes = append(d.Fixes, diag.Fix{Message: "close the string", Span: diag.Span{Start: l.pos(), End: l.pos()}, Replacement: `"`})
+			l.errors = append(l.errors, d)
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -132,16 +191,26 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) { //首字符为字母或下划线
 			tok.Literal = l.readIdentifier()          //读取整个标识符
 			tok.Type = token.LookupIdent(tok.Literal) //得到该标识符的tokenType
+			tok.Line, tok.Column = line, column
 			return tok
 		} else if isDigit(l.ch) { //首字符为数值
 			tok.Literal = l.readNumber()
 			//TODO 将来可以拓展数值类型
 			tok.Type = token.INT
+			tok.Line, tok.Column = line, column
 			return tok
 		} else {
-			tok = token.NewToken(token.ILLEGAL, l.ch)
+			//非 ASCII 字符整体作为一个 ILLEGAL
+			r, size := utf8.DecodeRuneInString(l.input[l.position:])
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position : l.position+size]}
+			for i := 1; i < size; i++ {
+				l.readChar()
+			}
+			tok.Line, tok.Column = line, column
+			l.errors = append(l.errors, diag.Errorf(diag.CodeIllegalCharacter, diag.TokenSpan(tok), "illegal character %q", r))
 		}
 	}
 	l.readChar()
+	tok.Line, tok.Column = line, column
 	return tok
 }
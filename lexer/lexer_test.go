@@ -82,3 +82,84 @@ func TestNextToken2(t *testing.T) {
 		t.Logf("tokentype %q, literal %q", tok.Type, tok.Literal)
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let a = 1;\n  a = \"x\";\n"
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"a", 1, 5},
+		{"=", 1, 7},
+		{"1", 1, 9},
+		{";", 1, 10},
+		{"a", 2, 3},
+		{"=", 2, 5},
+		{"x", 2, 7},
+		{";", 2, 10},
+		{"", 3, 1},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. Expected %d:%d, got %d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		literals []string //ILLEGAL 之前的词法单元和 ILLEGAL 本身
+		errors   []string
+	}{
+		{"let a = 1 @ 2;", nil, []string{"1:11: illegal character '@'"}},
+		{"a # 中", []string{"a", "#", "中"}, []string{"1:3: illegal character '#'", "1:5: illegal character '中'"}},
+		{"let s = \"abc;\n", nil, []string{"1:9: unterminated string literal"}},
+		{`let s = "abc";`, nil, nil},
+	}
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		var literals []string
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			literals = append(literals, tok.Literal)
+		}
+		if tt.literals != nil && fmt.Sprint(literals) != fmt.Sprint(tt.literals) {
+			t.Errorf("%q: expected tokens %q, got %q", tt.input, tt.literals, literals)
+		}
+		var errors []string
+		for _, d := range l.Errors() {
+			errors = append(errors, d.Error())
+		}
+		if fmt.Sprint(errors) != fmt.Sprint(tt.errors) {
+			t.Errorf("%q: expected errors %q, got %q", tt.input, tt.errors, errors)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// 开头\nlet a = 1; // 行尾  \n\"// 不是注释\" / 2 //\n"
+	l := NewLexer(input)
+	var literals []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		literals = append(literals, tok.Literal)
+	}
+	expected := []string{"let", "a", "=", "1", ";", "// 不是注释", "/", "2"}
+	if fmt.Sprint(literals) != fmt.Sprint(expected) {
+		t.Errorf("expected tokens %q, got %q", expected, literals)
+	}
+	comments := []Comment{
+		{token.Position{Line: 1, Column: 1}, "// 开头"},
+		{token.Position{Line: 2, Column: 12}, "// 行尾"},
+		{token.Position{Line: 3, Column: 23}, "//"},
+	}
+	if fmt.Sprint(l.Comments()) != fmt.Sprint(comments) {
+		t.Errorf("expected comments %v, got %v", comments, l.Comments())
+	}
+}
@@ -1,7 +1,11 @@
 package object
 
+import "sort"
+
+// 作用域：全局作用域按名字存放变量，局部作用域按 resolver 分配的槽位存放变量
 type Enviroment struct {
 	store map[string]Object
+	slots []Object
 	outer *Enviroment
 }
 
@@ -16,6 +20,12 @@ func NewEnclosedEnvironment(outer *Enviroment) *Enviroment {
 	return env
 }
 
+// 有 size 个槽位的局部作用域
+func NewLocalEnvironment(outer *Enviroment, size int) *Enviroment {
+	return &Enviroment{slots: make([]Object, size), outer: outer}
+}
+
+// 按名字查找变量，局部作用域没有名字，直接跳过
 func (e *Enviroment) Get(name string) (Object, bool, *Enviroment) {
 	obj, ok := e.store[name]
 	env := e
@@ -25,7 +35,32 @@ func (e *Enviroment) Get(name string) (Object, bool, *Enviroment) {
 	return obj, ok, env
 }
 
+// 按名字排序的变量名，只包含按名字存放的变量(不含外层作用域)
+func (e *Enviroment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (e *Enviroment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// 向外第 depth 个作用域中第 slot 个槽位的值，变量尚未定义时为 nil
+func (e *Enviroment) GetSlot(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	return e.slots[slot]
+}
+
+func (e *Enviroment) SetSlot(depth, slot int, val Object) {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.slots[slot] = val
+}
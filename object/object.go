@@ -2,6 +2,7 @@ package object
 
 import (
 	"TLanguage/ast"
+	"TLanguage/code"
 	"bytes"
 	"fmt"
 	"strings"
@@ -17,6 +18,12 @@ const (
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
+	BUILTIN_OBJ      = "BUILTIN"
+	HOST_OBJ         = "HOST"
+	EXCEPTION_OBJ    = "EXCEPTION"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -24,6 +31,16 @@ type Object interface {
 	Inspect() string
 }
 
+// 支持 obj.Field 读取的对象，失败时返回 *Error
+type MemberGetter interface {
+	GetMember(name string) Object
+}
+
+// 支持 obj.Field = value 赋值的对象，成功返回 nil，失败返回 *Error
+type MemberSetter interface {
+	SetMember(name string, val Object) Object
+}
+
 type Integer struct {
 	Value int64
 }
@@ -71,12 +88,38 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// 错误类别
+const (
+	RUNTIME_ERROR     = "RuntimeError"
+	THROWN_ERROR      = "Error"           //throw 抛出的错误
+	STEP_LIMIT_ERROR  = "StepLimitError"  //超出求值步数限制
+	TIMEOUT_ERROR     = "TimeoutError"    //超出运行时间限制
+	CANCELED_ERROR    = "CanceledError"   //context 被取消
+	DEPTH_LIMIT_ERROR = "RecursionError"  //超出函数调用深度限制
+	ALLOC_LIMIT_ERROR = "AllocationError" //超出字符串大小限制
+	EXIT_ERROR        = "Exit"            //exit(n) 结束程序
+)
+
+// 调用栈中的一帧：正在执行的函数及其执行到的位置
+type Frame struct {
+	Function string
+	Line     int
+	Column   int
+}
+
 type Error struct {
 	Message string
+	Kind    string
+	Stack   []Frame //从最外层到出错位置的调用栈
+	Status  int     //Kind 为 EXIT_ERROR 时的退出状态
 }
 
 func NewError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: RUNTIME_ERROR}
+}
+
+func NewErrorKind(kind string, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: kind}
 }
 
 func (e *Error) Type() ObjectType {
@@ -87,7 +130,137 @@ func (e *Error) Inspect() string {
 	return "ERROR: " + e.Message
 }
 
+// 执行限制类错误和 exit 必须终止求值，不能被 catch 捕获
+func (e *Error) Catchable() bool {
+	switch e.Kind {
+	case STEP_LIMIT_ERROR, TIMEOUT_ERROR, CANCELED_ERROR, EXIT_ERROR:
+		return false
+	default:
+		return true
+	}
+}
+
+// catch 捕获到的错误。与 Error 不同，它是普通的值，不会中断求值
+type Exception struct {
+	Error *Error
+}
+
+func (ex *Exception) Type() ObjectType {
+	return EXCEPTION_OBJ
+}
+
+func (ex *Exception) Inspect() string {
+	return ex.Error.Inspect()
+}
+
+// 脚本中可访问 e.message、e.kind、e.stack、e.line、e.column
+func (ex *Exception) GetMember(name string) Object {
+	e := ex.Error
+	switch name {
+	case "message":
+		return &String{Value: e.Message}
+	case "kind":
+		return &String{Value: e.Kind}
+	case "stack":
+		lines := []string{}
+		for _, f := range e.Stack {
+			lines = append(lines, fmt.Sprintf("%s:%d:%d", f.Function, f.Line, f.Column))
+		}
+		return &String{Value: strings.Join(lines, "\n")}
+	case "line", "column":
+		var pos int64
+		if len(e.Stack) > 0 {
+			f := e.Stack[len(e.Stack)-1]
+			pos = int64(f.Line)
+			if name == "column" {
+				pos = int64(f.Column)
+			}
+		}
+		return &Integer{Value: pos}
+	default:
+		return NewError("type error: ERROR has no member %s", name)
+	}
+}
+
+// 按调用顺序输出调用栈，file 为源文件名
+func (e *Error) Traceback(file string) string {
+	var out bytes.Buffer
+	if len(e.Stack) > 0 {
+		out.WriteString("Traceback (most recent call last):\n")
+	}
+	for _, entry := range CollapseStack(e.Stack) {
+		if entry.Repeat > 0 {
+			out.WriteString("  " + entry.String() + "\n")
+			continue
+		}
+		f := entry.Frame
+		out.WriteString(fmt.Sprintf("  File %q, line %d, column %d, in %s\n", file, f.Line, f.Column, f.Function))
+	}
+	out.WriteString(e.Kind + ": " + e.Message + "\n")
+	return out.String()
+}
+
+const (
+	maxRepeatPeriod = 4 //识别的重复段最多包含的帧数，相互递归的函数形成多帧的重复段
+	shownRepeats    = 3 //重复段输出的次数，其余的合并为一行说明
+)
+
+// StackEntry 是输出调用栈时的一项：Repeat 为0时是一帧，否则说明之前的 Frames 帧又重复了 Repeat 次
+type StackEntry struct {
+	Frame  Frame
+	Frames int
+	Repeat int
+}
+
+func (s StackEntry) String() string {
+	if s.Frames == 1 {
+		return fmt.Sprintf("[previous frame repeated %d more times]", s.Repeat)
+	}
+	return fmt.Sprintf("[previous %d frames repeated %d more times]", s.Frames, s.Repeat)
+}
+
+// CollapseStack 合并调用栈中连续重复的帧。递归过深出错时调用栈有上万层，
+// 重复的部分只输出前几次，其余以一行说明代替
+func CollapseStack(stack []Frame) []StackEntry {
+	var entries []StackEntry
+	for i := 0; i < len(stack); {
+		period, times := 1, 1
+		for p := 1; p <= maxRepeatPeriod; p++ {
+			//stack[i:i+p] 连续出现的次数
+			n := 1
+			for i+(n+1)*p <= len(stack) && equalFrames(stack[i:i+p], stack[i+n*p:i+(n+1)*p]) {
+				n++
+			}
+			if n > shownRepeats {
+				period, times = p, n
+				break
+			}
+		}
+		if times == 1 {
+			entries = append(entries, StackEntry{Frame: stack[i]})
+			i++
+			continue
+		}
+		for _, f := range stack[i : i+shownRepeats*period] {
+			entries = append(entries, StackEntry{Frame: f})
+		}
+		entries = append(entries, StackEntry{Frames: period, Repeat: times - shownRepeats})
+		i += times * period
+	}
+	return entries
+}
+
+func equalFrames(a, b []Frame) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type Function struct {
+	Name       string //let 绑定时的名字，匿名函数为空
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Enviroment
@@ -123,3 +296,67 @@ func (s *String) Type() ObjectType {
 func (s *String) Inspect() string {
 	return s.Value
 }
+
+type BuiltinFunction func(args ...Object) Object
+
+// 由宿主(Go)提供的函数
+type Builtin struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType {
+	return BUILTIN_OBJ
+}
+
+func (b *Builtin) Inspect() string {
+	return "builtin " + b.Name
+}
+
+// 编译后的函数
+type CompiledFunction struct {
+	Name          string //let 绑定时的名字，匿名函数为空
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Parameters    []string //参数名
+	Body          string   //函数体源码，用于与解释器相同的 Inspect 输出
+	LocalNames    []string //按槽位排列的局部变量名
+	FreeNames     []string //捕获的变量名
+	Lines         code.LineTable
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return "fn(" + strings.Join(cf.Parameters, ", ") + "){\n" + cf.Body + "\n}"
+}
+
+// 虚拟机中的函数值：编译后的函数及其捕获的变量
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType {
+	return FUNCTION_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return c.Fn.Inspect()
+}
+
+// 被闭包捕获的变量，捕获它的闭包与定义它的函数共享同一个单元
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType {
+	return CELL_OBJ
+}
+
+func (c *Cell) Inspect() string {
+	return c.Value.Inspect()
+}
@@ -0,0 +1,300 @@
+package optimizer
+
+import (
+	"TLanguage/ast"
+	"TLanguage/resolver"
+	"TLanguage/token"
+	"strconv"
+)
+
+// Optimize 就地优化语法树并返回 program：
+//   - 折叠整数、字符串、布尔常量组成的表达式
+//   - 删除条件为常量的 if 中不会执行的分支
+//   - 删除块中无条件 return 之后的语句
+//
+// 运行期才能报告的错误(如除以零、类型不匹配)保持不变，
+// 新生成的节点沿用被替换节点的源码位置。删除和替换节点会改变作用域的结构，
+// 优化后重新为局部变量分配槽位
+func Optimize(program *ast.Program) *ast.Program {
+	program.Statements = statements(program.Statements)
+	resolver.Resolve(program, nil)
+	return program
+}
+
+func statements(stmts []ast.Statement) []ast.Statement {
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, s := range stmts {
+		s = statement(s)
+		//块中没有 let 时，常量条件选中的分支可以直接展开到外层，不影响作用域
+		if es, ok := s.(*ast.ExpressionStatement); ok {
+			if ie, ok := es.Expression.(*ast.IfExpression); ok {
+				if block, ok := chosenBranch(ie); ok {
+					if block != nil && !declares(block) {
+						out = append(out, block.Statements...)
+						if returns(block) {
+							break
+						}
+						continue
+					}
+					//条件为假且没有 else 的 if 只在作为块的值时有意义
+					if block == nil && i != len(stmts)-1 {
+						continue
+					}
+				}
+			}
+		}
+		out = append(out, s)
+		if _, ok := s.(*ast.ReturnStatement); ok {
+			break
+		}
+	}
+	return out
+}
+
+// 已优化的块是否以 return 结束
+func returns(b *ast.BlockStatement) bool {
+	if len(b.Statements) == 0 {
+		return false
+	}
+	_, ok := b.Statements[len(b.Statements)-1].(*ast.ReturnStatement)
+	return ok
+}
+
+func block(b *ast.BlockStatement) *ast.BlockStatement {
+	if b != nil {
+		b.Statements = statements(b.Statements)
+	}
+	return b
+}
+
+func statement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		s.Value = expression(s.Value)
+	case *ast.AssignStatement:
+		s.Value = expression(s.Value)
+	case *ast.MemberAssignStatement:
+		s.Target.Object = expression(s.Target.Object)
+		s.Value = expression(s.Value)
+	case *ast.ReturnStatement:
+		s.ReturnValue = expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		s.Value = expression(s.Value)
+	case *ast.ExpressionStatement:
+		s.Expression = expression(s.Expression)
+	case *ast.BlockStatement:
+		block(s)
+	}
+	return s
+}
+
+func expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = expression(e.Right)
+		if folded := foldPrefix(e); folded != nil {
+			return folded
+		}
+	case *ast.InfixExpression:
+		e.Left = expression(e.Left)
+		e.Right = expression(e.Right)
+		if folded := foldInfix(e); folded != nil {
+			return folded
+		}
+	case *ast.IfExpression:
+		return ifExpression(e)
+	case *ast.WhileExpression:
+		e.Condition = expression(e.Condition)
+		block(e.Body)
+	case *ast.FunctionLiteral:
+		block(e.Body)
+	case *ast.CallExpression:
+		e.Function = expression(e.Function)
+		arguments(e.Arguments)
+	case *ast.PrintlnExpression:
+		arguments(e.Arguments)
+	case *ast.MemberExpression:
+		e.Object = expression(e.Object)
+	case *ast.TryExpression:
+		block(e.Block)
+		block(e.Catch)
+		block(e.Finally)
+	}
+	return e
+}
+
+func arguments(args []ast.Expression) {
+	for i, a := range args {
+		args[i] = expression(a)
+	}
+}
+
+func ifExpression(ie *ast.IfExpression) ast.Expression {
+	ie.Condition = expression(ie.Condition)
+	block(ie.Consequence)
+	block(ie.Alternative)
+	truthy, ok := constantTruthy(ie.Condition)
+	switch {
+	case !ok:
+		if ie.Another != nil {
+			elseIf(ie)
+		}
+	case truthy:
+		ie.Another, ie.Alternative = nil, nil
+		if e := singleExpression(ie.Consequence); e != nil {
+			return e
+		}
+	case ie.Another != nil:
+		return ifExpression(ie.Another)
+	default:
+		ie.Consequence = &ast.BlockStatement{Token: ie.Consequence.Token}
+		if e := singleExpression(ie.Alternative); e != nil {
+			return e
+		}
+	}
+	return ie
+}
+
+// 化简 else if 分支，其条件为常量时用选中的块作为 else 块
+func elseIf(ie *ast.IfExpression) {
+	another := ifExpression(ie.Another)
+	ie.Another = nil
+	if next, ok := another.(*ast.IfExpression); ok {
+		if branch, ok := chosenBranch(next); ok {
+			ie.Alternative = branch
+		} else {
+			ie.Another = next
+		}
+		return
+	}
+	pos := another.Pos()
+	ie.Alternative = &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{", Line: pos.Line, Column: pos.Column},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: ie.Token, Expression: another}},
+	}
+}
+
+// 条件为常量的 if 会执行的分支；条件为假且没有 else 时返回 nil
+func chosenBranch(ie *ast.IfExpression) (*ast.BlockStatement, bool) {
+	truthy, ok := constantTruthy(ie.Condition)
+	if !ok || ie.Another != nil {
+		return nil, false
+	}
+	if truthy {
+		return ie.Consequence, true
+	}
+	return ie.Alternative, true
+}
+
+// 只包含一个表达式语句的块可以用该表达式代替，块的作用域对它没有影响
+func singleExpression(b *ast.BlockStatement) ast.Expression {
+	if b == nil || len(b.Statements) != 1 {
+		return nil
+	}
+	if es, ok := b.Statements[0].(*ast.ExpressionStatement); ok && es.Expression != nil {
+		return es.Expression
+	}
+	return nil
+}
+
+// 块中是否直接定义了变量
+func declares(b *ast.BlockStatement) bool {
+	for _, s := range b.Statements {
+		if _, ok := s.(*ast.LetStatement); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 常量的真假，与解释器的 isTruthy 一致
+func constantTruthy(e ast.Expression) (truthy bool, ok bool) {
+	switch e := e.(type) {
+	case *ast.Boolean:
+		return e.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+func foldPrefix(pe *ast.PrefixExpression) ast.Expression {
+	pos := pe.Pos()
+	switch pe.Operator {
+	case "!":
+		if truthy, ok := constantTruthy(pe.Right); ok {
+			return newBoolean(!truthy, pos)
+		}
+	case "-":
+		if il, ok := pe.Right.(*ast.IntegerLiteral); ok {
+			return newInteger(-il.Value, pos)
+		}
+	}
+	return nil
+}
+
+// 只折叠结果确定且不会出错的运算；字符串的 == 比较的是对象本身，不折叠
+func foldInfix(ie *ast.InfixExpression) ast.Expression {
+	pos := ie.Pos()
+	switch left := ie.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := ie.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := left.Value, right.Value
+		switch ie.Operator {
+		case "+":
+			return newInteger(l+r, pos)
+		case "-":
+			return newInteger(l-r, pos)
+		case "*":
+			return newInteger(l*r, pos)
+		case "/":
+			if r != 0 {
+				return newInteger(l/r, pos)
+			}
+		case "<":
+			return newBoolean(l < r, pos)
+		case ">":
+			return newBoolean(l > r, pos)
+		case "==":
+			return newBoolean(l == r, pos)
+		case "!=":
+			return newBoolean(l != r, pos)
+		}
+	case *ast.StringLiteral:
+		if right, ok := ie.Right.(*ast.StringLiteral); ok && ie.Operator == "+" {
+			return newString(left.Value+right.Value, pos)
+		}
+	case *ast.Boolean:
+		right, ok := ie.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+		switch ie.Operator {
+		case "==":
+			return newBoolean(left.Value == right.Value, pos)
+		case "!=":
+			return newBoolean(left.Value != right.Value, pos)
+		}
+	}
+	return nil
+}
+
+func newInteger(value int64, pos token.Position) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal, Line: pos.Line, Column: pos.Column}, Value: value}
+}
+
+func newString(value string, pos token.Position) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value, Line: pos.Line, Column: pos.Column}, Value: value}
+}
+
+func newBoolean(value bool, pos token.Position) *ast.Boolean {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Line: pos.Line, Column: pos.Column}
+	if value {
+		tok.Type, tok.Literal = token.TRUE, "true"
+	}
+	return &ast.Boolean{Token: tok, Value: value}
+}
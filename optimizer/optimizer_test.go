@@ -0,0 +1,104 @@
+package optimizer
+
+import (
+	"TLanguage/ast"
+	"TLanguage/evaluator"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"TLanguage/token"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2 * 60 * 60", "7200"},
+		{"-5 + 10 / 2", "0"},
+		{"1 < 2 == true", "true"},
+		{"!(1 > 2)", "true"},
+		{`"a" + "b" + "c"`, "abc"},
+		{"x + 2 * 3", "(x + 6)"},
+		{"10 / 0", "(10 / 0)"},
+		{"1 + true", "(1 + true)"},
+		{`"a" == "a"`, "(a == a)"},
+		{"if (1 < 2) { 10 } else { 20 }", "10"},
+		{"if (false) { 10 } else { 20 }", "20"},
+		{"if (false) { 10 } else if (x) { 20 } else { 30 }", "if x 20 else 30"},
+		{"if (x) { 10 } else if (true) { 20 } else { 30 }", "if x 10 else 20"},
+		{"if (x) { 10 } else if (false) { 20 }", "if x 10 "},
+		{"if (false) { 10 }", "if false  "},
+		{"if (false) { 10 }; 5", "5"},
+		{"if (true) { x = 1; 2 }; 3", "x x = 1;23"},
+		{"if (true) { let x = 1; x }; 3", "if true let x = 1;x 3"},
+		{"fn(){ 1; return 2; 3; 4 }", "fn()1return 2;"},
+		{"fn(){ if (true) { return 1; }; 2 }", "fn()return 1;"},
+		{"while (x < 2 + 3) { x = x + 1 * 1; }", "while (x < 5) x x = (x + 1); "},
+	}
+	for _, tt := range tests {
+		program := Optimize(parse(t, tt.input))
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestOptimizeKeepsPositions(t *testing.T) {
+	program := Optimize(parse(t, "let a = 1;\nlet b = 2 * 3 + 4;"))
+	let := program.Statements[1].(*ast.LetStatement)
+	lit, ok := let.Value.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("value is not *ast.IntegerLiteral. got %T", let.Value)
+	}
+	//折叠后的字面量位于原表达式的运算符处
+	if want := (token.Position{Line: 2, Column: 15}); lit.Pos() != want {
+		t.Errorf("wrong position. expected %v, got %v", want, lit.Pos())
+	}
+}
+
+func TestOptimizePreservesBehavior(t *testing.T) {
+	inputs := []string{
+		"let x = 2 * 60 * 60; x",
+		"let f = fn(n){ if (n < 2) { return 1; }; return n * f(n - 1); 0 }; f(10)",
+		"let x = 1; if (true) { x = x + 1; }; x",
+		"let x = 1; if (true) { let x = 5; }; x",
+		"let x = 1; if (false) { x = 2; }",
+		"if (false) { 1 } else if (2 > 1) { 2 } else { 3 }",
+		`let s = "a" + "b"; println(s, 1 + 2); s`,
+		"let f = fn(){ if (true) { return 1; }; println(2); 3 }; f()",
+		"10 / (5 - 5)",
+		"-true",
+		"1 + true",
+	}
+	for _, input := range inputs {
+		var expectedOut, gotOut bytes.Buffer
+		expected := evaluator.New(&expectedOut, io.Discard).Eval(parse(t, input), object.NewEnvironment())
+		got := evaluator.New(&gotOut, io.Discard).Eval(Optimize(parse(t, input)), object.NewEnvironment())
+		if expected == nil || got == nil {
+			if expected != got {
+				t.Errorf("%q: expected %v, got %v", input, expected, got)
+			}
+			continue
+		}
+		if got.Type() != expected.Type() || got.Inspect() != expected.Inspect() {
+			t.Errorf("%q: expected %s(%s), got %s(%s)", input, expected.Type(), expected.Inspect(), got.Type(), got.Inspect())
+		}
+		if gotOut.String() != expectedOut.String() {
+			t.Errorf("%q: expected output %q, got %q", input, expectedOut.String(), gotOut.String())
+		}
+	}
+}
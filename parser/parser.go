@@ -2,9 +2,12 @@ package parser
 
 import (
 	"TLanguage/ast"
+	"TLanguage/diag"
 	"TLanguage/lexer"
+	"TLanguage/resolver"
 	"TLanguage/token"
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -17,6 +20,7 @@ const (
 	PRODUCT     // * or /
 	PREFIX      // -X or !X
 	CALL        // fn(X)
+	MEMBER      // obj.Field
 )
 
 // 优先级表
@@ -30,6 +34,7 @@ var precedences = map[token.TokenType]int{
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
+	token.DOT:      MEMBER,
 }
 
 type (
@@ -39,7 +44,15 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []*diag.Diagnostic
+
+	stmtToken     token.Token //当前语句的第一个token，用于给出拼写建议
+	prevStmtToken token.Token //上一条语句的第一个token
+
+	//出错后进入恐慌模式，不再报告错误，直到跳到下一条语句
+	panicking  bool
+	errorPos   token.Position //引起恐慌的错误位置
+	blockDepth int            //所在代码块的嵌套层数
 
 	curToken  token.Token //当前的token
 	peekToken token.Token //下一个token
@@ -51,7 +64,7 @@ type Parser struct {
 func NewParser(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
-		errors:         []string{},
+		errors:         []*diag.Diagnostic{},
 		prefixParseFns: make(map[token.TokenType]prefixParseFn),
 		infixParseFns:  make(map[token.TokenType]infixParseFn),
 	}
@@ -68,6 +81,7 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)  //fn
 	p.registerPrefix(token.PRINTLN, p.parsePrintlnExpression) //println
 	p.registerPrefix(token.STRING, p.parseStringLiteral)      //""
+	p.registerPrefix(token.TRY, p.parseTryExpression)         //try
 	//注册中缀解析函数
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -78,6 +92,7 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -126,6 +141,19 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 		Function: function,
 	}
 	exp.Arguments = p.parseCallArguments()
+	exp.Rparen = p.curToken
+	return exp
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{
+		Token:  p.curToken,
+		Object: object,
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	return exp
 }
 
@@ -135,6 +163,7 @@ func (p *Parser) parsePrintlnExpression() ast.Expression {
 	}
 	p.nextToken()
 	exp.Arguments = p.parseCallArguments()
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -176,13 +205,54 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+// Errors 返回词法错误和语法错误，按位置排序
+func (p *Parser) Errors() []*diag.Diagnostic {
+	errors := append(append([]*diag.Diagnostic{}, p.l.Errors()...), p.errors...)
+	sort.SliceStable(errors, func(i, j int) bool {
+		return before(errors[i].Span.Start, errors[j].Span.Start)
+	})
+	return errors
+}
+
+// 在 tok 处记录一条错误。恐慌模式下的错误多半由前一个错误引起，不再记录；
+// ILLEGAL 已由词法分析报告，只进入恐慌模式
+func (p *Parser) errorAt(code string, tok token.Token, format string, args ...interface{}) *diag.Diagnostic {
+	d := diag.Errorf(code, diag.TokenSpan(tok), format, args...)
+	if p.panicking {
+		return d
+	}
+	p.panicking, p.errorPos = true, tok.Pos()
+	if tok.Type == token.ILLEGAL {
+		return d
+	}
+	//语句以拼错的关键字开头时，如 whlie (x) { ... }，错误出现在 { 处，此时 { 是新语句的开头
+	start := p.stmtToken
+	if start.Type == token.LBRACE {
+		start = p.prevStmtToken
+	}
+	if start.Type == token.IDENT {
+		d.SuggestName(start.Literal, diag.TokenSpan(start), token.Keywords())
+	}
+	p.errors = append(p.errors, d)
+	return d
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, but got %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	d := p.errorAt(diag.CodeUnexpectedToken, p.peekToken, "expected next token to be %s, but got %s", t, p.peekToken.Type)
+	d.Expected, d.Found = string(t), string(p.peekToken.Type)
+	span := diag.TokenSpan(p.peekToken)
+	if t == token.RPAREN && p.peekToken.Type == token.ASSIGN {
+		d.Hints = append(d.Hints, "use `==` to compare values")
+		d.Fixes = append(d.Fixes, diag.Fix{Message: "replace `=` with `==`", Span: span, Replacement: "=="})
+		return
+	}
+	if p.peekToken.Type == token.EOF {
+		d.Notes = append(d.Notes, "the input ended before the construct was complete")
+	}
+	//缺少的右括号可以直接插入
+	if t == token.RPAREN || t == token.RBRACE {
+		d.Fixes = append(d.Fixes, diag.Fix{Message: fmt.Sprintf("insert `%s`", t), Span: diag.Span{Start: span.Start, End: span.Start}, Replacement: string(t)})
+	}
 }
 
 func (p *Parser) nextToken() {
@@ -190,23 +260,88 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
+// ParseProgram 解析整个程序。没有语法错误时同时为局部变量分配槽位(见 resolver.Resolve)，
+// 之后的求值和编译只读取语法树，同一棵语法树可以在多个 goroutine 中同时求值
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		program.Statements = append(program.Statements, stmt)
-		p.nextToken()
+		if stmt := p.parseStatementOrSync(); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+		}
+	}
+	if len(p.Errors()) == 0 {
+		resolver.Resolve(program, nil)
 	}
 	return program
 }
 
+// 解析一条语句并移到下一条语句的开头。语句中有错误时丢弃该语句，跳到可以继续解析的位置并返回 nil
+func (p *Parser) parseStatementOrSync() ast.Statement {
+	stmt := p.parseStatement()
+	if p.panicking {
+		p.synchronize()
+		return nil
+	}
+	p.nextToken()
+	return stmt
+}
+
+// 可以作为同步点的语句开头
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:    true,
+	token.RETURN: true,
+	token.THROW:  true,
+	token.IF:     true,
+	token.WHILE:  true,
+	token.TRY:    true,
+}
+
+// 从出错的位置开始跳过 token，停在 ; 之后、语句关键字或所在代码块的 } 上。
+// 跳过的部分中成对的花括号整体跳过
+func (p *Parser) synchronize() {
+	p.panicking = false
+	for !p.curTokenIs(token.EOF) && before(p.curToken.Pos(), p.errorPos) {
+		p.nextToken()
+	}
+	nesting := 0
+	for !p.curTokenIs(token.EOF) {
+		switch {
+		case p.curTokenIs(token.LBRACE):
+			nesting++
+		case p.curTokenIs(token.RBRACE):
+			if nesting == 0 && p.blockDepth > 0 {
+				return
+			}
+			//顶层多余的 } 一并跳过
+			if nesting > 0 {
+				nesting--
+			}
+		case nesting > 0:
+		case p.curTokenIs(token.SEMICOLON):
+			p.nextToken()
+			return
+		case statementKeywords[p.curToken.Type]:
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// 位置 a 是否在 b 之前
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
 func (p *Parser) parseStatement() ast.Statement {
+	p.prevStmtToken, p.stmtToken = p.stmtToken, p.curToken
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	case token.IDENT:
 		if p.peekToken.Type == token.ASSIGN {
 			return p.parseAssignStatement()
@@ -240,9 +375,13 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
+	//obj.Field = value
+	if member, ok := stmt.Expression.(*ast.MemberExpression); ok && p.peekTokenIs(token.ASSIGN) {
+		return p.parseMemberAssignStatement(member)
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -270,15 +409,23 @@ func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	stmt := &ast.AssignStatement{Token: p.curToken}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(token.ASSIGN) {
-		p.peekError(token.ASSIGN)
 		return nil
 	}
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
-	} else {
-		p.peekError(token.SEMICOLON)
+	}
+	return stmt
+}
+
+func (p *Parser) parseMemberAssignStatement(target *ast.MemberExpression) *ast.MemberAssignStatement {
+	p.nextToken()
+	stmt := &ast.MemberAssignStatement{Token: p.curToken, Target: target}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
 	}
 	return stmt
 }
@@ -307,17 +454,36 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{
 		Token: p.curToken,
 	}
 	block.Statements = []ast.Statement{}
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		block.Statements = append(block.Statements, stmt)
-		p.nextToken()
+		if stmt := p.parseStatementOrSync(); stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+	}
+	if p.curTokenIs(token.EOF) {
+		d := p.errorAt(diag.CodeUnclosedBlock, p.curToken, "expected next token to be }, but got EOF")
+		d.Expected, d.Found = token.RBRACE, token.EOF
+		d.Fixes = append(d.Fixes, diag.Fix{Message: "insert `}`", Span: diag.Span{Start: p.curToken.Pos(), End: p.curToken.Pos()}, Replacement: token.RBRACE})
+		d.Notes = append(d.Notes, fmt.Sprintf("the block opened at %d:%d is not closed", block.Token.Line, block.Token.Column))
 	}
+	block.Rbrace = p.curToken
 	return block
 }
 
@@ -344,8 +510,8 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		d := p.errorAt(diag.CodeInvalidInteger, p.curToken, "could not parse %q as integer", p.curToken.Literal)
+		d.Notes = append(d.Notes, "integers are 64-bit signed numbers")
 		return nil
 	}
 	lit.Value = value
@@ -405,8 +571,8 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	d := p.errorAt(diag.CodeExpectedExpression, p.curToken, "no prefix parse function for %s found", t)
+	d.Expected, d.Found = "expression", string(t)
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -492,3 +658,43 @@ func (il *IntegerLiteral) TokenLiteral() string {
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{
+		Token: p.curToken,
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+	if expression.Catch == nil && expression.Finally == nil {
+		d := p.errorAt(diag.CodeMissingHandler, p.peekToken, "expected catch or finally after try block, but got %s", p.peekToken.Type)
+		d.Expected, d.Found = "catch or finally", string(p.peekToken.Type)
+		return nil
+	}
+	return expression
+}
@@ -2,8 +2,10 @@ package parser
 
 import (
 	"TLanguage/ast"
+	"TLanguage/diag"
 	"TLanguage/lexer"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -42,7 +44,7 @@ func TestLetStatements(t *testing.T) {
 			return
 		}
 		val := stmt.(*ast.LetStatement).Value
-		if !testLiteralExpression(t, val, tt.expectedIdentifier) {
+		if !testLiteralExpression(t, val, tt.expectedValue) {
 			return
 		}
 	}
@@ -83,7 +85,7 @@ func TestAssignStatements(t *testing.T) {
 			return
 		}
 		val := stmt.(*ast.AssignStatement).Value
-		if !testLiteralExpression(t, val, tt.expectedIdentifier) {
+		if !testLiteralExpression(t, val, tt.expectedValue) {
 			return
 		}
 	}
@@ -104,7 +106,7 @@ func checkParserErrors(t *testing.T, p *Parser) {
 func TestReturnStatement(t *testing.T) {
 	input := `return 5;
 	return 10;
-	return 993 322;`
+	return 993322;`
 	l := lexer.NewLexer(input)
 	p := NewParser(l)
 	program := p.ParseProgram()
@@ -749,3 +751,199 @@ func testInfixExpression(t *testing.T, exp ast.Expression, left interface{}, ope
 	}
 	return true
 }
+
+func TestMemberExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"user.Name", "user.Name"},
+		{"user.Greet(1, 2)", "user.Greet(1, 2)"},
+		{"a.b.c + 1", "(a.b.c + 1)"},
+		{"user.Name = 5;", "user.Name = 5;"},
+	}
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryExpressionParsing(t *testing.T) {
+	input := `try { throw "x"; } catch (e) { e } finally { 1 }`
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. Got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ExpressionStatement. Got %T", program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. Got %T", stmt.Expression)
+	}
+	if _, ok := exp.Block.Statements[0].(*ast.ThrowStatement); !ok {
+		t.Errorf("try block does not start with ast.ThrowStatement. Got %T", exp.Block.Statements[0])
+	}
+	if !testIdentifier(t, exp.Param, "e") {
+		return
+	}
+	if exp.Catch == nil || exp.Finally == nil {
+		t.Errorf("catch or finally block missing")
+	}
+
+	p = NewParser(lexer.NewLexer("try { 1 }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected error for try without catch or finally")
+	}
+}
+
+func TestErrorDiagnostics(t *testing.T) {
+	tests := []struct {
+		input   string
+		line    int
+		column  int
+		length  int
+		message string
+		hint    string
+	}{
+		{"let x = (1;", 1, 11, 1, "expected next token to be ), but got ;", ""},
+		{"let x = 1;\nwhlie (x) { x }", 2, 11, 1, "no prefix parse function for { found", "did you mean `while`?"},
+		{"if (x = 1) { 2 }", 1, 7, 1, "expected next token to be ), but got =", "use `==` to compare values"},
+		{`let = "abc";`, 1, 5, 1, "expected next token to be IDENT, but got =", ""},
+		{"let x = 99999999999999999999;", 1, 9, 20, `could not parse "99999999999999999999" as integer`, ""},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", tt.input)
+			continue
+		}
+		d := p.Errors()[0]
+		start, length := d.Span.Start, d.Span.End.Column-d.Span.Start.Column
+		if start.Line != tt.line || start.Column != tt.column || length != tt.length || d.Message != tt.message {
+			t.Errorf("%q: expected %d:%d (%d) %q, got %d:%d (%d) %q", tt.input, tt.line, tt.column, tt.length, tt.message,
+				start.Line, start.Column, length, d.Message)
+		}
+		hint := ""
+		if len(d.Hints) > 0 {
+			hint = d.Hints[0]
+		}
+		if hint != tt.hint {
+			t.Errorf("%q: expected hint %q, got %q", tt.input, tt.hint, hint)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input      string
+		errors     []string
+		statements string //恢复后保留的语句
+	}{
+		{
+			"let x = add(1, 2;\nlet y = 3;",
+			[]string{"1:17: expected next token to be ), but got ;"},
+			"let y = 3;",
+		},
+		{
+			"let = 1; let a = 2; let b = (a + ; b",
+			[]string{"1:5: expected next token to be IDENT, but got =", "1:34: no prefix parse function for ; found"},
+			"let a = 2;b",
+		},
+		{
+			"let f = fn(x) { let y = ); x }; f(1)",
+			[]string{"1:25: no prefix parse function for ) found"},
+			"let f = fn(x)x;f(1)",
+		},
+		{
+			"if (x = 1) { a; b }\nreturn 2;",
+			[]string{"1:7: expected next token to be ), but got ="},
+			"return 2;",
+		},
+		{
+			"} let a = 1;",
+			[]string{"1:1: no prefix parse function for } found"},
+			"let a = 1;",
+		},
+		{
+			"let f = fn() { 1",
+			[]string{"1:17: expected next token to be }, but got EOF"},
+			"",
+		},
+		{
+			"while (x) { let = 1; y } z",
+			[]string{"1:17: expected next token to be IDENT, but got ="},
+			"while x y z",
+		},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		var errors []string
+		for _, d := range p.Errors() {
+			errors = append(errors, d.Error())
+		}
+		if fmt.Sprint(errors) != fmt.Sprint(tt.errors) {
+			t.Errorf("%q: expected errors %q, got %q", tt.input, tt.errors, errors)
+		}
+		ast.Walk(program, func(node ast.Node) bool {
+			if s, ok := node.(ast.Statement); ok && reflect.ValueOf(s).IsNil() {
+				t.Errorf("%q: nil statement in the program", tt.input)
+			}
+			return true
+		})
+		if program.String() != tt.statements {
+			t.Errorf("%q: expected statements %q, got %q", tt.input, tt.statements, program.String())
+		}
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	tests := []struct {
+		input           string
+		code            string
+		expected, found string
+		fix             string //第一个修改，格式为 "行:列-行:列 替换文本"
+	}{
+		{"let x = (1;", diag.CodeUnexpectedToken, ")", ";", "1:11-1:11 )"},
+		{"if (x = 1) { 2 }", diag.CodeUnexpectedToken, ")", "=", "1:7-1:8 =="},
+		{"let x = 1 +;", diag.CodeExpectedExpression, "expression", ";", ""},
+		{"fn() { 1", diag.CodeUnclosedBlock, "}", "EOF", "1:9-1:9 }"},
+		{"try { 1 } x", diag.CodeMissingHandler, "catch or finally", "IDENT", ""},
+		{"whlie (x) { 1 }", diag.CodeExpectedExpression, "expression", "{", "1:1-1:6 while"},
+		{"let x = 1 @ 2;", diag.CodeIllegalCharacter, "", "", ""},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%q: expected 1 error, got %v", tt.input, errors)
+			continue
+		}
+		d := errors[0]
+		if d.Severity != diag.Error || d.Code != tt.code || d.Expected != tt.expected || d.Found != tt.found {
+			t.Errorf("%q: expected %s %s %q/%q, got %s %s %q/%q", tt.input, diag.Error, tt.code, tt.expected, tt.found,
+				d.Severity, d.Code, d.Expected, d.Found)
+		}
+		fix := ""
+		if len(d.Fixes) > 0 {
+			f := d.Fixes[0]
+			fix = fmt.Sprintf("%d:%d-%d:%d %s", f.Span.Start.Line, f.Span.Start.Column, f.Span.End.Line, f.Span.End.Column, f.Replacement)
+		}
+		if fix != tt.fix {
+			t.Errorf("%q: expected fix %q, got %q", tt.input, tt.fix, fix)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package repl
+
+import (
+	"TLanguage/ast"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"TLanguage/token"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const commandHelp = `:env            list the variables defined in the session
+:ast <src>      show the syntax tree of src
+:tokens <src>   show the tokens of src
+:load <file>    evaluate a file in the session
+:reset          forget all variables and history
+:save <file>    write the inputs that ran successfully to a file
+:quit           leave the REPL
+:help           show this help
+`
+
+// 会话命令的名称，用于 Tab 补全
+var commandNames = []string{":env", ":ast", ":tokens", ":load", ":reset", ":save", ":quit", ":help"}
+
+// 执行以 : 开头的会话命令，返回 false 表示退出
+func (s *session) command(input string) bool {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprint(s.out, commandHelp)
+	case ":env":
+		s.listEnv()
+	case ":ast":
+		p := parser.NewParser(lexer.NewLexer(arg))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			s.printParseErrors("<repl>", arg, p.Errors())
+			break
+		}
+		_ = ast.Fprint(s.out, program)
+	case ":tokens":
+		l := lexer.NewLexer(arg)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			fmt.Fprintf(s.out, "%d:%d\t%s\t%q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		}
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(s.out, "usage: :load <file>")
+			break
+		}
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		s.eval(string(src), arg, false)
+	case ":reset":
+		*s = *newSession(s.out)
+	case ":save":
+		if arg == "" {
+			fmt.Fprintln(s.out, "usage: :save <file>")
+			break
+		}
+		var content strings.Builder
+		for _, src := range s.history {
+			content.WriteString(terminate(src) + "\n")
+		}
+		if err := os.WriteFile(arg, []byte(content.String()), 0644); err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		fmt.Fprintf(s.out, "saved %d inputs to %s\n", len(s.history), arg)
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, type :help for the list of commands\n", name)
+	}
+	return true
+}
+
+// 为不以分号结尾的输入补上分号，加在最后一个词法单元之后、行尾注释之前。
+// 否则保存的输入连在一起会被解析成别的程序：5 与下一条输入 -1 会成为 5 - 1
+func terminate(src string) string {
+	src = strings.TrimRight(src, " \t\r\n")
+	l := lexer.NewLexer(src)
+	var last token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		last = tok
+	}
+	if last.Type == "" || last.Type == token.SEMICOLON {
+		return src
+	}
+	end := len(src)
+	for _, c := range l.Comments() {
+		if c.Pos.Line > last.Line || c.Pos.Line == last.Line && c.Pos.Column > last.Column {
+			lineStart := 0
+			for line := 1; line < c.Pos.Line; line++ {
+				lineStart += strings.IndexByte(src[lineStart:], '\n') + 1
+			}
+			end = lineStart + c.Pos.Column - 1 //列号按字节计算
+			break
+		}
+	}
+	code := strings.TrimRight(src[:end], " \t\r\n")
+	return code + ";" + src[len(code):]
+}
+
+// 按名字列出会话中的变量，函数只显示参数
+func (s *session) listEnv() {
+	for _, name := range s.env.Names() {
+		val, _, _ := s.env.Get(name)
+		var text string
+		switch val := val.(type) {
+		case *object.Function:
+			params := make([]string, len(val.Parameters))
+			for i, p := range val.Parameters {
+				params[i] = p.Value
+			}
+			text = "fn(" + strings.Join(params, ", ") + "){...}"
+		case *object.String:
+			text = fmt.Sprintf("%q", val.Value)
+		default:
+			text = val.Inspect()
+		}
+		fmt.Fprintf(s.out, "%s = %s\n", name, text)
+	}
+}
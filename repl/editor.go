@@ -0,0 +1,446 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 历史文件最多保留的行数
+const maxHistory = 1000
+
+// 用户按下 Ctrl-C，放弃当前输入
+var errInterrupt = errors.New("interrupt")
+
+// 逐行读取输入
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// 不是终端时按普通文本逐行读取
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) readLine(prompt string) (string, error) {
+	_, _ = fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// 输入过的行，path 不为空时同时追加到历史文件
+type history struct {
+	entries []string
+	path    string
+}
+
+// 历史文件的位置：用户配置目录下的 tl/history
+func historyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tl", "history")
+}
+
+// 读取历史文件，文件不存在时为空。历史只是辅助功能，读写出错都忽略
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	//文件过长时只保留最近的部分
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+		_ = os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	return h
+}
+
+// 记录一行输入，跳过空行和与上一条相同的行
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if h.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	_, _ = f.WriteString(line + "\n")
+	_ = f.Close()
+}
+
+// 终端上的行编辑器：左右移动光标、上下翻阅历史、Ctrl-R 搜索历史、Tab 补全
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  *history
+	complete func(prefix string) []string //返回以 prefix 开头的候选词
+	raw      func() (func(), error)       //读取一行期间切换到原始模式，为 nil 时不切换
+
+	prompt  string
+	buf     []rune
+	pos     int  //光标在 buf 中的位置
+	pending rune //搜索结束时留待处理的按键
+}
+
+// 标准输入输出都是终端时返回行编辑器，否则返回 nil
+func newTerminalEditor(in io.Reader, out io.Writer, complete func(string) []string) *editor {
+	inFile, ok := in.(*os.File)
+	if !ok || !isTerminal(inFile.Fd()) {
+		return nil
+	}
+	if outFile, ok := out.(*os.File); !ok || !isTerminal(outFile.Fd()) {
+		return nil
+	}
+	return &editor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		history:  loadHistory(historyPath()),
+		complete: complete,
+		raw:      func() (func(), error) { return makeRaw(inFile.Fd()) },
+	}
+}
+
+// 读取一行，空行上按 Ctrl-D 返回 io.EOF，按 Ctrl-C 返回 errInterrupt
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	//翻阅历史时 index 指向当前显示的条目，等于条目数时为正在输入的行
+	index, current := len(e.history.entries), ""
+	e.refresh()
+	for {
+		r, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			e.write("\n")
+			line := string(e.buf)
+			e.history.add(line)
+			return line, nil
+		case ctrl('C'):
+			e.write("^C\n")
+			return "", errInterrupt
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				e.write("\n")
+				return "", io.EOF
+			}
+			e.delete()
+		case ctrl('A'), keyHome:
+			e.pos = 0
+		case ctrl('E'), keyEnd:
+			e.pos = len(e.buf)
+		case ctrl('B'), keyLeft:
+			if e.pos > 0 {
+				e.pos--
+			}
+		case ctrl('F'), keyRight:
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case 127, ctrl('H'):
+			if e.pos > 0 {
+				e.pos--
+				e.delete()
+			}
+		case keyDelete:
+			e.delete()
+		case ctrl('K'):
+			e.buf = e.buf[:e.pos]
+		case ctrl('U'):
+			e.buf = e.buf[e.pos:]
+			e.pos = 0
+		case ctrl('W'):
+			start := e.pos
+			for start > 0 && e.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.buf[start-1] != ' ' {
+				start--
+			}
+			e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+			e.pos = start
+		case ctrl('L'):
+			e.write("\x1b[H\x1b[2J")
+		case ctrl('P'), keyUp:
+			if index > 0 {
+				if index == len(e.history.entries) {
+					current = string(e.buf)
+				}
+				index--
+				e.setLine(e.history.entries[index])
+			}
+		case ctrl('N'), keyDown:
+			if index < len(e.history.entries) {
+				index++
+				if index == len(e.history.entries) {
+					e.setLine(current)
+				} else {
+					e.setLine(e.history.entries[index])
+				}
+			}
+		case ctrl('R'):
+			if err := e.search(); err != nil {
+				return "", err
+			}
+		case '\t':
+			e.completeWord()
+		default:
+			if unicode.IsPrint(r) {
+				e.buf = append(e.buf[:e.pos], append([]rune{r}, e.buf[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		e.refresh()
+	}
+}
+
+// 特殊按键，取 Unicode 私有区的值以免与普通字符冲突
+const (
+	keyUp rune = 0xE000 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// 读取一个按键，把终端的转义序列转换为特殊按键
+func (e *editor) readKey() (rune, error) {
+	if r := e.pending; r != 0 {
+		e.pending = 0
+		return r, nil
+	}
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r != '[' && r != 'O' {
+		return keyUnknown, nil
+	}
+	//序列形如 ESC [ 数字... 结束符
+	var param []rune
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if r < '0' || r > '9' && r != ';' {
+			break
+		}
+		param = append(param, r)
+	}
+	switch r {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch string(param) {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+// 删除光标处的字符
+func (e *editor) delete() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+func (e *editor) setLine(line string) {
+	e.buf = []rune(line)
+	e.pos = len(e.buf)
+}
+
+func (e *editor) write(s string) {
+	_, _ = io.WriteString(e.out, s)
+}
+
+// 重画当前行并把光标移到编辑位置
+func (e *editor) refresh() {
+	e.redraw(e.prompt+string(e.buf), utf8.RuneCountInString(e.prompt)+e.pos)
+}
+
+func (e *editor) redraw(line string, cursor int) {
+	var b strings.Builder
+	b.WriteString("\r" + line + "\x1b[K\r")
+	if cursor > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", cursor)
+	}
+	e.write(b.String())
+}
+
+// 向前增量搜索历史：输入的字符加入搜索词，再按 Ctrl-R 找更早的匹配，
+// 回车执行找到的行，Ctrl-G 或 Ctrl-C 取消，其他按键接受找到的行后继续编辑
+func (e *editor) search() error {
+	original := string(e.buf)
+	var query []rune
+	entries := e.history.entries
+	index := len(entries)
+	//从 from 开始向前查找包含搜索词的条目
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if strings.Contains(entries[i], string(query)) {
+				index = i
+				return
+			}
+		}
+	}
+	for {
+		match := ""
+		if index < len(entries) {
+			match = entries[index]
+		}
+		status := fmt.Sprintf("(reverse-i-search)`%s': ", string(query))
+		e.redraw(status+match, utf8.RuneCountInString(status))
+		r, err := e.readKey()
+		if err != nil {
+			return err
+		}
+		switch {
+		case r == ctrl('R'):
+			if index > 0 {
+				find(index - 1)
+			}
+		case r == ctrl('G') || r == ctrl('C'):
+			e.setLine(original)
+			return nil
+		case r == 127 || r == ctrl('H'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				index = len(entries)
+				find(len(entries) - 1)
+			}
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			find(min(index, len(entries)-1))
+		default:
+			if match != "" {
+				e.setLine(match)
+			} else {
+				e.setLine(original)
+			}
+			e.pending = r
+			return nil
+		}
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// 补全光标前的词：只有一个候选时直接补全，有多个时补全公共前缀，
+// 无法再补全时列出所有候选
+func (e *editor) completeWord() {
+	start := e.pos
+	for start > 0 && isWordRune(e.buf[start-1]) {
+		start--
+	}
+	//行首的 : 命令
+	if start == 1 && e.buf[0] == ':' {
+		start = 0
+	}
+	prefix := string(e.buf[start:e.pos])
+	if prefix == "" || e.complete == nil {
+		return
+	}
+	candidates := e.complete(prefix)
+	if len(candidates) == 0 {
+		e.write("\a")
+		return
+	}
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		insert := []rune(common[len(prefix):])
+		e.buf = append(e.buf[:e.pos], append(insert, e.buf[e.pos:]...)...)
+		e.pos += len(insert)
+		return
+	}
+	if len(candidates) > 1 {
+		e.write("\n" + strings.Join(candidates, "  ") + "\n")
+	}
+}
+
+// 从 words 中选出以 prefix 开头的词，去重并排序
+func matchPrefix(prefix string, words []string) []string {
+	seen := map[string]bool{}
+	var matches []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) && !seen[w] {
+			seen[w] = true
+			matches = append(matches, w)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
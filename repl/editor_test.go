@@ -0,0 +1,128 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 以给定的按键和历史创建编辑器
+func newTestEditor(keys string, entries ...string) *editor {
+	return &editor{
+		in:      bufio.NewReader(strings.NewReader(keys)),
+		out:     io.Discard,
+		history: &history{entries: entries},
+		complete: func(prefix string) []string {
+			return matchPrefix(prefix, []string{"while", "width", "println", "let"})
+		},
+	}
+}
+
+func TestEditorKeys(t *testing.T) {
+	tests := []struct {
+		keys     string
+		history  []string
+		expected string
+	}{
+		{"abc\r", nil, "abc"},
+		{"abc\x1b[D\x1b[DX\r", nil, "aXbc"},
+		{"bc\x01a\x05d\r", nil, "abcd"},
+		{"abc\x1b[H\x1b[3~\x1b[F!\r", nil, "bc!"},
+		{"abcd\x7f\x7f\r", nil, "ab"},
+		{"let a = 1\x17b\r", nil, "let a = b"},
+		{"abcdef\x02\x02\x0b\r", nil, "abcd"},
+		{"abcdef\x02\x02\x15\r", nil, "ef"},
+		{"\x1b[A\r", []string{"let a = 1;", "a + 2"}, "a + 2"},
+		{"\x1b[A\x1b[A\r", []string{"let a = 1;", "a + 2"}, "let a = 1;"},
+		{"x\x1b[A\x1b[A\x1b[B\x1b[B\r", []string{"let a = 1;", "a + 2"}, "x"},
+		{"\x12let\r", []string{"let a = 1;", "a + 2", "let b = 2;"}, "let b = 2;"},
+		{"\x12let\x12\r", []string{"let a = 1;", "a + 2", "let b = 2;"}, "let a = 1;"},
+		{"\x12a +\x1b[D\x1b[D!\r", []string{"a + 2"}, "a +! 2"},
+		{"old\x12a\x07\r", []string{"a + 2"}, "old"},
+		{"pr\t(1)\r", nil, "println(1)"},
+		{"w\t\r", nil, "w"},
+		{"wi\t\r", nil, "width"},
+		{"whi\t\r", nil, "while"},
+		{"x\t\r", nil, "x"},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.keys, tt.history...)
+		line, err := e.readLine(PROMPT)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.keys, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.keys, tt.expected, line)
+		}
+	}
+}
+
+func TestEditorInterrupt(t *testing.T) {
+	if _, err := newTestEditor("abc\x03").readLine(PROMPT); !errors.Is(err, errInterrupt) {
+		t.Errorf("Ctrl-C should interrupt, got %v", err)
+	}
+	if _, err := newTestEditor("\x04").readLine(PROMPT); err != io.EOF {
+		t.Errorf("Ctrl-D on an empty line should end input, got %v", err)
+	}
+	line, err := newTestEditor("ab\x02\x04\r").readLine(PROMPT)
+	if err != nil || line != "a" {
+		t.Errorf("Ctrl-D should delete under the cursor, got %q, %v", line, err)
+	}
+	//Ctrl-C 放弃整条多行输入
+	e := newTestEditor("fn(x) {\rx\x03\r")
+	if input, ok := readInput(e); !ok || input != "" {
+		t.Errorf("interrupted input should be discarded, got %q", input)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tl", "history")
+	h := loadHistory(path)
+	for _, line := range []string{"let a = 1;", "a", "a", "", "a + 1"} {
+		h.add(line)
+	}
+	expected := []string{"let a = 1;", "a", "a + 1"}
+	if !reflect.DeepEqual(h.entries, expected) {
+		t.Errorf("expected entries %q, got %q", expected, h.entries)
+	}
+	if reloaded := loadHistory(path); !reflect.DeepEqual(reloaded.entries, expected) {
+		t.Errorf("expected reloaded entries %q, got %q", expected, reloaded.entries)
+	}
+
+	lines := make([]string, maxHistory+10)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", i%7+1)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if h := loadHistory(path); len(h.entries) != maxHistory || h.entries[0] != lines[10] {
+		t.Errorf("history should keep the last %d lines, got %d", maxHistory, len(h.entries))
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newSession(io.Discard)
+	s.eval("let width = 1; let wide = fn() { 2 };", "<test>", false)
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"w", []string{"while", "wide", "width"}},
+		{"fi", []string{"finally"}},
+		{"wid", []string{"wide", "width"}},
+		{":re", []string{":reset"}},
+		{"zz", nil},
+	}
+	for _, tt := range tests {
+		if got := s.complete(tt.prefix); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("complete(%q) = %q, want %q", tt.prefix, got, tt.expected)
+		}
+	}
+}
@@ -1,44 +1,141 @@
 package repl
 
 import (
+	"TLanguage/diag"
 	"TLanguage/evaluator"
 	"TLanguage/lexer"
 	"TLanguage/object"
 	"TLanguage/parser"
+	"TLanguage/token"
 	"bufio"
-	"fmt"
+	"errors"
 	"io"
+	"strings"
 )
 
 const PROMPT = ">> "
 
+// 输入尚未结束时的续行提示符
+const CONTINUE_PROMPT = ".. "
+
+// 一次交互会话的状态
+type session struct {
+	out         io.Writer
+	env         *object.Enviroment
+	interpreter *evaluator.Interpreter
+	history     []string //成功执行的输入，供 :save 使用
+}
+
+func newSession(out io.Writer) *session {
+	return &session{out: out, env: object.NewEnvironment(), interpreter: evaluator.New(out, out)}
+}
+
+// Start 运行交互会话。输入输出都是终端时支持行编辑、历史记录和 Tab 补全，
+// 否则按普通文本逐行读取
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	s := newSession(out)
+	var reader lineReader = &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	if e := newTerminalEditor(in, out, s.complete); e != nil {
+		reader = e
+	}
 	for {
-		_, _ = fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, ok := readInput(reader)
+		if !ok {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.NewLexer(line)
-		p := parser.NewParser(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
-			continue
+		input := strings.TrimSpace(line)
+		switch {
+		case input == "":
+		case strings.HasPrefix(input, ":"):
+			if !s.command(input) {
+				return
+			}
+		default:
+			s.eval(line, "<repl>", true)
+		}
+	}
+}
+
+// 解析并执行一段源码，成功时记入历史。file 为报错时显示的文件名，
+// echo 为 true 时输出非 null 的结果
+func (s *session) eval(src, file string, echo bool) bool {
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParseErrors(file, src, p.Errors())
+		return false
+	}
+	evaluated := s.interpreter.Eval(program, s.env)
+	if err, ok := evaluated.(*object.Error); ok {
+		_, _ = io.WriteString(s.out, diag.Traceback(file, src, err, diag.Color(s.out)))
+		return false
+	}
+	s.history = append(s.history, src)
+	if echo && evaluated != nil && evaluated != evaluator.NULL {
+		_, _ = io.WriteString(s.out, evaluated.Inspect())
+		_, _ = io.WriteString(s.out, "\n")
+	}
+	return true
+}
+
+// 读取一条完整的输入：括号未配对或字符串未结束时以续行提示符继续读取，
+// 输入在中途结束时返回已读取的部分，按 Ctrl-C 时放弃已读取的部分
+func readInput(reader lineReader) (string, bool) {
+	input, err := reader.readLine(PROMPT)
+	if errors.Is(err, errInterrupt) {
+		return "", true
+	}
+	if err != nil {
+		return "", false
+	}
+	for incomplete(input) {
+		line, err := reader.readLine(CONTINUE_PROMPT)
+		if errors.Is(err, errInterrupt) {
+			return "", true
+		}
+		if err != nil {
+			break
 		}
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil && evaluated != evaluator.NULL {
-			_, _ = io.WriteString(out, evaluated.Inspect())
-			_, _ = io.WriteString(out, "\n")
+		input += "\n" + line
+	}
+	return input, true
+}
+
+// Tab 补全的候选：关键字和会话中定义的标识符，以 : 开头时为会话命令
+func (s *session) complete(prefix string) []string {
+	if strings.HasPrefix(prefix, ":") {
+		return matchPrefix(prefix, commandNames)
+	}
+	return matchPrefix(prefix, append(token.Keywords(), s.env.Names()...))
+}
+
+// 输入中是否有未闭合的圆括号、花括号或字符串。多余的右括号留给语法分析报错
+func incomplete(input string) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '/' && i+1 < len(input) && input[i+1] == '/':
+			//注释中的括号不计入，跳到行尾
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
 		}
 	}
+	return inString || depth > 0
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		_, _ = io.WriteString(out, "\t"+msg+"\n")
+// 以指向源码的形式输出语法错误，file 为显示的文件名，src 为出错的输入
+func (s *session) printParseErrors(file, src string, diags []*diag.Diagnostic) {
+	color := diag.Color(s.out)
+	for _, d := range diags {
+		_ = diag.Fprint(s.out, file, src, d, color)
 	}
 }
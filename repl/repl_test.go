@@ -0,0 +1,119 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let a = 1;", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n x + 1\n}", false},
+		{"println(1,", true},
+		{`println("a {")`, false},
+		{`let s = "open`, true},
+		{"while (a < 3) { if (a) { a }", true},
+		{"}", false},
+		{"let f = fn(x) { // (\n x }", false},
+		{"let a = 1; // {", false},
+		{`println("//") {`, true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	input := strings.Join([]string{
+		"let add = fn(a, b) {",
+		"  a + b",
+		"};",
+		"add(1,",
+		"  2)",
+		`let s = "two`,
+		`lines";`,
+		"s",
+		"let broken = fn( {",
+		"})",
+		"1 +",
+	}, "\n")
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+	expected := ">> .. .. >> .. 3\n>> .. >> two\nlines\n>> .. "
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Fatalf("unexpected output.\nexpected prefix:\n%q\ngot:\n%q", expected, out.String())
+	}
+	rest := strings.TrimPrefix(out.String(), expected)
+	//语法错误在输入完整后才报告，且只报告一次
+	parts := strings.Split(rest, ">> ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "error[") || !strings.HasPrefix(parts[1], "error[") || parts[2] != "" {
+		t.Errorf("parse errors should be reported once per complete input, got %q", rest)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.tl")
+	if err := os.WriteFile(lib, []byte("let double = fn(x) {\n  x * 2\n};\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := filepath.Join(dir, "session.tl")
+	input := strings.Join([]string{
+		`let name = "t";`,
+		"let n = 1 +;",
+		":load " + lib,
+		"double(21)",
+		"let x = 5 // five",
+		"-1",
+		"if (x > 1) { x }",
+		"missing",
+		":env",
+		":ast -a + 1",
+		`:tokens let s = "x";`,
+		":save " + saved,
+		":reset",
+		":env",
+		"name",
+		":bogus",
+		":quit",
+		"println(\"unreachable\")",
+	}, "\n")
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+	got := out.String()
+	for _, expected := range []string{
+		"42\n",
+		"double = fn(x){...}\nname = \"t\"\n",
+		"ExpressionStatement\n    Expression: InfixExpression +\n      Left: PrefixExpression -\n        Right: Identifier a\n      Right: IntegerLiteral 1\n",
+		"1:1\tLET\t\"let\"\n1:5\tIDENT\t\"s\"\n1:7\t=\t\"=\"\n1:9\tSTRING\t\"x\"\n1:12\t;\t\";\"\n",
+		"saved 6 inputs to " + saved,
+		"identifier not found: name",
+		"unknown command :bogus",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("output should contain %q, got:\n%s", expected, got)
+		}
+	}
+	if strings.Contains(got, "unreachable") {
+		t.Errorf(":quit should end the session")
+	}
+	content, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//每条输入以分号结尾，重新加载时 5 与 -1 不会被解析为 5 - 1
+	expected := "let name = \"t\";\nlet double = fn(x) {\n  x * 2\n};\ndouble(21);\nlet x = 5; // five\n-1;\nif (x > 1) { x };\n"
+	if string(content) != expected {
+		t.Errorf("wrong saved session.\nexpected:\n%q\ngot:\n%q", expected, string(content))
+	}
+}
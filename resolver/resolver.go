@@ -0,0 +1,228 @@
+package resolver
+
+import (
+	"TLanguage/ast"
+	"TLanguage/token"
+	"fmt"
+)
+
+// 无法解析的标识符
+type Error struct {
+	Pos  token.Position
+	Name string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: identifier not found: %s", e.Pos.Line, e.Pos.Column, e.Name)
+}
+
+// 一个局部作用域，运行时对应一个按槽位存放变量的环境
+type scope struct {
+	slots    map[string]int  //作用域中定义的全部变量
+	defined  map[string]bool //按执行顺序到当前位置已经定义的变量
+	function int             //直接在该作用域中执行的代码所处的函数嵌套层数
+}
+
+func newScope(function int) *scope {
+	return &scope{slots: map[string]int{}, defined: map[string]bool{}, function: function}
+}
+
+// 变量在当前位置是否可见：直接执行的代码只能看到已经执行过 let 的变量，
+// 嵌套函数在调用时才查找变量，可以看到作用域中的全部变量(包括函数自身，以支持递归)
+func (s *scope) lookup(name string, function int) (int, bool) {
+	slot, ok := s.slots[name]
+	if !ok || (!s.defined[name] && function <= s.function) {
+		return 0, false
+	}
+	return slot, true
+}
+
+type resolver struct {
+	scopes    []*scope //局部作用域，最内层在末尾
+	globals   *scope   //顶层作用域，变量按名字查找，不分配槽位
+	functions int      //当前的函数嵌套层数
+	isGlobal  func(name string) bool
+	reported  map[string]bool
+	errors    []*Error
+}
+
+// Resolve 为 node 中的每个局部变量分配(深度, 槽位)并写入语法树，
+// 返回既不是局部变量、也不是顶层 let 定义的变量且 isGlobal 不认可的标识符，每个名字只报告一次。
+// isGlobal 可为 nil，用于识别宿主或之前的 REPL 输入定义的全局变量。
+// 未解析的标识符按全局变量处理，执行到时才报错
+func Resolve(node ast.Node, isGlobal func(name string) bool) []*Error {
+	r := &resolver{globals: newScope(0), isGlobal: isGlobal, reported: map[string]bool{}}
+	if program, ok := node.(*ast.Program); ok {
+		for _, s := range program.Statements {
+			if let, ok := s.(*ast.LetStatement); ok {
+				r.globals.slots[let.Name.Value] = 0
+			}
+		}
+		r.statements(program.Statements, r.globals)
+		return r.errors
+	}
+	r.node(node)
+	return r.errors
+}
+
+func (r *resolver) node(node ast.Node) {
+	switch node := node.(type) {
+	case ast.Statement:
+		r.statement(node, r.current())
+	case ast.Expression:
+		r.expression(node)
+	}
+}
+
+// 当前 let 定义变量所在的作用域
+func (r *resolver) current() *scope {
+	if len(r.scopes) == 0 {
+		return r.globals
+	}
+	return r.scopes[len(r.scopes)-1]
+}
+
+func (r *resolver) statements(stmts []ast.Statement, s *scope) {
+	for _, stmt := range stmts {
+		r.statement(stmt, s)
+	}
+}
+
+// 块中有 let 时创建新的作用域，否则其中的变量属于外层作用域
+func (r *resolver) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+	s := newScope(r.functions)
+	for _, stmt := range b.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			if _, ok := s.slots[let.Name.Value]; !ok {
+				s.slots[let.Name.Value] = len(s.slots)
+			}
+		}
+	}
+	b.NumSlots = len(s.slots)
+	if b.NumSlots == 0 {
+		r.statements(b.Statements, r.current())
+		return
+	}
+	r.scopes = append(r.scopes, s)
+	r.statements(b.Statements, s)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *resolver) statement(stmt ast.Statement, s *scope) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.expression(stmt.Value)
+		s.defined[stmt.Name.Value] = true
+		if s == r.globals {
+			stmt.Name.Local = false
+			return
+		}
+		stmt.Name.Local, stmt.Name.Depth, stmt.Name.Slot = true, 0, s.slots[stmt.Name.Value]
+	case *ast.AssignStatement:
+		r.expression(stmt.Value)
+		r.identifier(stmt.Name)
+	case *ast.MemberAssignStatement:
+		r.expression(stmt.Target.Object)
+		r.expression(stmt.Value)
+	case *ast.ReturnStatement:
+		r.expression(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(stmt.Value)
+	case *ast.ExpressionStatement:
+		r.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		r.block(stmt)
+	}
+}
+
+func (r *resolver) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		r.identifier(exp)
+	case *ast.PrefixExpression:
+		r.expression(exp.Right)
+	case *ast.InfixExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Right)
+	case *ast.IfExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Consequence)
+		if exp.Another != nil {
+			r.expression(exp.Another)
+		}
+		r.block(exp.Alternative)
+	case *ast.WhileExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Body)
+	case *ast.FunctionLiteral:
+		r.function(exp)
+	case *ast.CallExpression:
+		r.expression(exp.Function)
+		for _, a := range exp.Arguments {
+			r.expression(a)
+		}
+	case *ast.PrintlnExpression:
+		for _, a := range exp.Arguments {
+			r.expression(a)
+		}
+	case *ast.MemberExpression:
+		r.expression(exp.Object)
+	case *ast.TryExpression:
+		r.block(exp.Block)
+		if exp.Catch != nil {
+			//catch 的参数单独占一个作用域
+			s := newScope(r.functions)
+			s.slots[exp.Param.Value] = 0
+			s.defined[exp.Param.Value] = true
+			exp.Param.Local, exp.Param.Depth, exp.Param.Slot = true, 0, 0
+			r.scopes = append(r.scopes, s)
+			r.block(exp.Catch)
+			r.scopes = r.scopes[:len(r.scopes)-1]
+		}
+		r.block(exp.Finally)
+	}
+}
+
+// 参数按位置占用槽位，同名参数以最后一个为准；没有参数时不创建作用域
+func (r *resolver) function(fl *ast.FunctionLiteral) {
+	r.functions++
+	defer func() {
+		r.functions--
+	}()
+	if len(fl.Parameters) == 0 {
+		r.block(fl.Body)
+		return
+	}
+	s := newScope(r.functions)
+	for i, p := range fl.Parameters {
+		s.slots[p.Value] = i
+		s.defined[p.Value] = true
+		p.Local, p.Depth, p.Slot = true, 0, i
+	}
+	r.scopes = append(r.scopes, s)
+	r.block(fl.Body)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *resolver) identifier(ident *ast.Identifier) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if slot, ok := r.scopes[i].lookup(ident.Value, r.functions); ok {
+			ident.Local, ident.Depth, ident.Slot = true, len(r.scopes)-1-i, slot
+			return
+		}
+	}
+	ident.Local, ident.Depth, ident.Slot = false, 0, 0
+	if _, ok := r.globals.lookup(ident.Value, r.functions); ok {
+		return
+	}
+	if r.isGlobal != nil && r.isGlobal(ident.Value) {
+		return
+	}
+	if !r.reported[ident.Value] {
+		r.reported[ident.Value] = true
+		r.errors = append(r.errors, &Error{Pos: ident.Pos(), Name: ident.Value})
+	}
+}
@@ -0,0 +1,130 @@
+package resolver_test
+
+import (
+	"TLanguage/ast"
+	"TLanguage/lexer"
+	"TLanguage/parser"
+	"TLanguage/resolver"
+	"TLanguage/token"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+// 按出现顺序收集名为 name 的标识符(不含成员名)
+func identifiers(program *ast.Program, name string) []*ast.Identifier {
+	var idents []*ast.Identifier
+	ast.Walk(program, func(n ast.Node) bool {
+		if me, ok := n.(*ast.MemberExpression); ok {
+			ast.Walk(me.Object, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Identifier); ok && ident.Value == name {
+					idents = append(idents, ident)
+				}
+				return true
+			})
+			return false
+		}
+		if ident, ok := n.(*ast.Identifier); ok && ident.Value == name {
+			idents = append(idents, ident)
+		}
+		return true
+	})
+	return idents
+}
+
+type binding struct {
+	local       bool
+	depth, slot int
+}
+
+func TestResolveBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected []binding
+	}{
+		{"let a = 1; a", "a", []binding{{false, 0, 0}, {false, 0, 0}}},
+		{"fn(a, b){ b }", "b", []binding{{true, 0, 1}, {true, 0, 1}}},
+		{"fn(a){ let b = 1; a + b }", "a", []binding{{true, 0, 0}, {true, 1, 0}}},
+		{"fn(a){ let b = 1; let c = 2; c }", "c", []binding{{true, 0, 1}, {true, 0, 1}}},
+		{"fn(x){ fn(){ fn(y){ x } } }", "x", []binding{{true, 0, 0}, {true, 1, 0}}},
+		//没有 let 的块不占作用域
+		{"fn(x){ if (x) { x } }", "x", []binding{{true, 0, 0}, {true, 0, 0}, {true, 0, 0}}},
+		{"fn(x){ if (x) { let y = 1; x } }", "x", []binding{{true, 0, 0}, {true, 0, 0}, {true, 1, 0}}},
+		//let 的值中同名变量指向外层
+		{"fn(x){ let x = x + 1; x }", "x", []binding{{true, 0, 0}, {true, 0, 0}, {true, 1, 0}, {true, 0, 0}}},
+		//函数体中可以引用之后才定义的变量
+		{"fn(){ let f = fn(){ g() }; let g = fn(){ 1 }; }", "g", []binding{{true, 0, 1}, {true, 0, 1}}},
+		{"try { 1 } catch (e) { e.message }", "e", []binding{{true, 0, 0}, {true, 0, 0}}},
+		{"let x = 1; fn(){ x = 2; }", "x", []binding{{false, 0, 0}, {false, 0, 0}}},
+	}
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if errs := resolver.Resolve(program, nil); len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", tt.input, errs)
+			continue
+		}
+		idents := identifiers(program, tt.name)
+		if len(idents) != len(tt.expected) {
+			t.Errorf("%q: expected %d identifiers named %s, got %d", tt.input, len(tt.expected), tt.name, len(idents))
+			continue
+		}
+		for i, ident := range idents {
+			got := binding{ident.Local, ident.Depth, ident.Slot}
+			if got != tt.expected[i] {
+				t.Errorf("%q: %s #%d expected %+v, got %+v", tt.input, tt.name, i, tt.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestResolveBlockSlots(t *testing.T) {
+	program := parse(t, "if (true) { let a = 1; let b = 2; let a = 3; } else { 4 }")
+	ie := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	resolver.Resolve(program, nil)
+	if ie.Consequence.NumSlots != 2 {
+		t.Errorf("consequence should have 2 slots, got %d", ie.Consequence.NumSlots)
+	}
+	if ie.Alternative.NumSlots != 0 {
+		t.Errorf("alternative should have no slots, got %d", ie.Alternative.NumSlots)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		isGlobal func(string) bool
+		expected []resolver.Error
+	}{
+		{"a", nil, []resolver.Error{{token.Position{Line: 1, Column: 1}, "a"}}},
+		{"let f = fn(){ g(x, x) };", nil, []resolver.Error{
+			{token.Position{Line: 1, Column: 15}, "g"},
+			{token.Position{Line: 1, Column: 17}, "x"},
+		}},
+		{"println(a);\nlet a = 1;", nil, []resolver.Error{{token.Position{Line: 1, Column: 9}, "a"}}},
+		{"if (true) { let a = 1; }; a", nil, []resolver.Error{{token.Position{Line: 1, Column: 27}, "a"}}},
+		{"b = 1;", nil, []resolver.Error{{token.Position{Line: 1, Column: 1}, "b"}}},
+		{"let f = fn(){ a }; let a = 1; f()", nil, nil},
+		{"user.Name", func(name string) bool { return name == "user" }, nil},
+	}
+	for _, tt := range tests {
+		errs := resolver.Resolve(parse(t, tt.input), tt.isGlobal)
+		if len(errs) != len(tt.expected) {
+			t.Errorf("%q: expected %d errors, got %v", tt.input, len(tt.expected), errs)
+			continue
+		}
+		for i, err := range errs {
+			if *err != tt.expected[i] {
+				t.Errorf("%q: error %d expected %+v, got %+v", tt.input, i, tt.expected[i], *err)
+			}
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package token
 
+import "sort"
+
 type TokenType string
 
 const (
@@ -27,6 +29,7 @@ const (
 
 	//分隔符
 	COMMA     = ","
+	DOT       = "."
 	SEMICOLON = ";"
 	LPAREN    = "("
 	RPAREN    = ")"
@@ -43,6 +46,10 @@ const (
 	RETURN   = "RETURN"
 	WHILE    = "WHILE"
 	PRINTLN  = "PRINTLN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 // 关键字映射
@@ -56,11 +63,27 @@ var keywords = map[string]TokenType{
 	"return":  RETURN,
 	"while":   WHILE,
 	"println": PRINTLN,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
 }
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int //所在行，从1开始
+	Column  int //所在列，从1开始
+}
+
+// 源码中的位置
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (t Token) Pos() Position {
+	return Position{Line: t.Line, Column: t.Column}
 }
 
 func NewToken(tokenType TokenType, ch byte) Token {
@@ -71,6 +94,16 @@ func MakeTwoCharToken(tokenType TokenType, lit string) Token {
 	return Token{Type: tokenType, Literal: lit}
 }
 
+// Keywords 返回所有关键字，按字母顺序排列
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"TLanguage/evaluator"
+	"TLanguage/object"
+)
+
+// 一次函数调用的栈帧。参数与局部变量位于 stack[basePointer:]，
+// 被调函数位于 stack[basePointer-1]
+type Frame struct {
+	cl          *object.Closure
+	ip          int //下一条要执行的指令
+	basePointer int
+}
+
+// 调用栈顶的函数。tail 为 true 时复用当前栈帧，调用深度不变
+func (vm *VM) callFunction(numArgs int, tail bool) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		fn := callee.Fn
+		if numArgs < fn.NumParameters {
+			return object.NewError("wrong number of arguments. got=%d, want=%d", numArgs, fn.NumParameters)
+		}
+		var basePointer int
+		if tail {
+			frame := &vm.frames[len(vm.frames)-1]
+			basePointer = frame.basePointer
+			copy(vm.stack[basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+			frame.cl = callee
+			frame.ip = 0
+			vm.sp = basePointer + numArgs
+		} else {
+			maxDepth := vm.Limits.MaxDepth
+			if maxDepth <= 0 {
+				maxDepth = evaluator.DefaultMaxDepth
+			}
+			if len(vm.frames)-1 >= maxDepth {
+				return object.NewErrorKind(object.DEPTH_LIMIT_ERROR, "maximum call depth exceeded: %d", maxDepth)
+			}
+			basePointer = vm.sp - numArgs
+			vm.frames = append(vm.frames, Frame{cl: callee, basePointer: basePointer})
+		}
+		//多余的参数与局部变量的槽位必须清空，否则会被当作已定义的变量或已捕获的单元
+		top := basePointer + fn.NumLocals
+		for vm.sp < top {
+			vm.push(nil)
+		}
+		for i := basePointer + fn.NumParameters; i < top || i < basePointer+numArgs; i++ {
+			vm.stack[i] = nil
+		}
+		vm.sp = top
+		return nil
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp -= numArgs + 1
+		result := callee.Fn(args...)
+		if result == nil {
+			result = NULL
+		}
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		vm.push(result)
+		return nil
+	default:
+		return object.NewError("not a function: %s", callee.Type())
+	}
+}
@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"TLanguage/code"
+	"TLanguage/object"
+)
+
+var operatorNames = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// 二元运算，规则与错误信息与 evaluator 一致
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			return vm.executeIntegerOperation(op, l.Value, r.Value)
+		}
+	}
+	operator := operatorNames[op]
+	switch {
+	case op == code.OpEqual:
+		vm.push(nativeBoolToBooleanObject(left == right))
+	case op == code.OpNotEqual:
+		vm.push(nativeBoolToBooleanObject(left != right))
+	case left.Type() != right.Type():
+		return object.NewError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	case left.Type() == object.STRING_OBJ && op == code.OpAdd:
+		leftVal := left.(*object.String).Value
+		rightVal := right.(*object.String).Value
+		size := int64(len(leftVal) + len(rightVal))
+		if vm.Limits.MaxAllocSize > 0 && size > vm.Limits.MaxAllocSize {
+			return object.NewErrorKind(object.ALLOC_LIMIT_ERROR, "string of %d bytes exceeds allocation limit %d", size, vm.Limits.MaxAllocSize)
+		}
+		vm.push(&object.String{Value: leftVal + rightVal})
+	default:
+		return object.NewError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return nil
+}
+
+func (vm *VM) executeIntegerOperation(op code.Opcode, left, right int64) *object.Error {
+	switch op {
+	case code.OpAdd:
+		vm.push(&object.Integer{Value: left + right})
+	case code.OpSub:
+		vm.push(&object.Integer{Value: left - right})
+	case code.OpMul:
+		vm.push(&object.Integer{Value: left * right})
+	case code.OpDiv:
+		if right == 0 {
+			return object.NewError("division by zero")
+		}
+		vm.push(&object.Integer{Value: left / right})
+	case code.OpEqual:
+		vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		vm.push(nativeBoolToBooleanObject(left != right))
+	case code.OpGreaterThan:
+		vm.push(nativeBoolToBooleanObject(left > right))
+	case code.OpLessThan:
+		vm.push(nativeBoolToBooleanObject(left < right))
+	}
+	return nil
+}
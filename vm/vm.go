@@ -0,0 +1,406 @@
+package vm
+
+import (
+	"TLanguage/code"
+	"TLanguage/compiler"
+	"TLanguage/evaluator"
+	"TLanguage/object"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+const StackSize = 2048
+const GlobalsSize = 65536
+
+var (
+	NULL  = evaluator.NULL
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+)
+
+// 已登记的异常处理地址
+type handler struct {
+	frame int //登记时的栈帧
+	sp    int //登记时的栈顶
+	addr  int //catch/finally 代码的地址
+}
+
+type VM struct {
+	Stdout io.Writer //println 的输出
+	Stderr io.Writer
+	Limits evaluator.Limits //执行限制，MaxSteps 为最多执行的指令数
+
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int //指向下一个空闲的栈槽，栈顶为 stack[sp-1]
+
+	frames   []Frame
+	handlers []handler
+
+	ctx   context.Context
+	steps int64
+}
+
+func New(bytecode *compiler.Bytecode, stdout, stderr io.Writer) *VM {
+	return NewWithGlobalsStore(bytecode, make([]object.Object, len(bytecode.Globals)), stdout, stderr)
+}
+
+// 使用给定的全局变量存储，用于REPL中逐行执行或由宿主预先设置全局变量
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object, stdout, stderr io.Writer) *VM {
+	mainFn := &object.CompiledFunction{
+		Name:         "<main>",
+		NumLocals:    bytecode.NumLocals,
+		LocalNames:   bytecode.LocalNames,
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+	}
+	if len(s) < len(bytecode.Globals) {
+		s = append(s, make([]object.Object, len(bytecode.Globals)-len(s))...)
+	}
+	return &VM{
+		Stdout:      stdout,
+		Stderr:      stderr,
+		constants:   bytecode.Constants,
+		globals:     s,
+		globalNames: bytecode.Globals,
+		stack:       make([]object.Object, StackSize),
+		sp:          bytecode.NumLocals, //顶层块的局部变量位于栈底
+		frames:      []Frame{{cl: &object.Closure{Fn: mainFn}}},
+	}
+}
+
+func (vm *VM) Run() object.Object {
+	return vm.RunContext(context.Background())
+}
+
+// 在ctx下执行，返回顶层代码的结果；出错时返回 *object.Error
+func (vm *VM) RunContext(ctx context.Context) object.Object {
+	if vm.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vm.Limits.Timeout)
+		defer cancel()
+	}
+	vm.ctx = ctx
+	return vm.run()
+}
+
+func (vm *VM) run() object.Object {
+	for {
+		var err *object.Error
+		if err = vm.step(); err != nil {
+			if result := vm.raise(err); result != nil {
+				return result
+			}
+			continue
+		}
+		frame := &vm.frames[len(vm.frames)-1]
+		ins := frame.cl.Fn.Instructions
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+		frame.ip++
+
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.push(vm.constants[idx])
+		case code.OpPop:
+			vm.pop()
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			err = vm.executeBinaryOperation(op)
+		case code.OpMinus:
+			right := vm.pop()
+			if integer, ok := right.(*object.Integer); ok {
+				vm.push(&object.Integer{Value: -integer.Value})
+			} else {
+				err = object.NewError("unknown operator: -%s", right.Type())
+			}
+		case code.OpBang:
+			vm.push(nativeBoolToBooleanObject(!isTruthy(vm.pop())))
+		case code.OpTrue:
+			vm.push(TRUE)
+		case code.OpFalse:
+			vm.push(FALSE)
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+		case code.OpJumpNotTruthy:
+			frame.ip += 2
+			if !isTruthy(vm.pop()) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+
+		case code.OpGetGlobal:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			val := vm.globals[idx]
+			if val == nil {
+				err = object.NewError("identifier not found: " + vm.globalNames[idx])
+				break
+			}
+			vm.push(val)
+		case code.OpSetGlobal:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if vm.globals[idx] == nil {
+				err = object.NewError("unknown identier:%v", vm.globalNames[idx])
+				break
+			}
+			vm.globals[idx] = vm.pop()
+		case code.OpDefineGlobal:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[idx] = vm.pop()
+		case code.OpGetLocal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			val := vm.stack[frame.basePointer+idx]
+			if cell, ok := val.(*object.Cell); ok {
+				val = cell.Value
+			}
+			if val == nil {
+				err = object.NewError("identifier not found: " + frame.cl.Fn.LocalNames[idx])
+				break
+			}
+			vm.push(val)
+		case code.OpSetLocal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			slot := &vm.stack[frame.basePointer+idx]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+		case code.OpDefineLocal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.stack[frame.basePointer+idx] = vm.pop()
+		case code.OpGetFree:
+			idx := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			val := frame.cl.Free[idx].Value
+			if val == nil {
+				err = object.NewError("identifier not found: " + frame.cl.Fn.FreeNames[idx])
+				break
+			}
+			vm.push(val)
+		case code.OpSetFree:
+			idx := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			frame.cl.Free[idx].Value = vm.pop()
+		case code.OpCaptureLocal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			slot := &vm.stack[frame.basePointer+idx]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				//第一次被捕获时装箱，此后定义它的函数也通过单元读写
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+			vm.push(cell)
+		case code.OpCaptureFree:
+			idx := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(frame.cl.Free[idx])
+		case code.OpClosure:
+			idx := code.ReadUint16(ins[ip+1:])
+			numFree := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			free := make([]*object.Cell, numFree)
+			for i := 0; i < numFree; i++ {
+				free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+			}
+			vm.sp -= numFree
+			vm.push(&object.Closure{Fn: vm.constants[idx].(*object.CompiledFunction), Free: free})
+
+		case code.OpCall, code.OpTailCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			err = vm.callFunction(numArgs, op == code.OpTailCall)
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if len(vm.frames) == 1 {
+				return returnValue
+			}
+			vm.sp = frame.basePointer - 1
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(returnValue)
+		case code.OpPrintln:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.println(vm.stack[vm.sp-numArgs : vm.sp])
+			vm.sp -= numArgs
+			vm.push(NULL)
+
+		case code.OpGetMember:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			name := vm.constants[idx].(*object.String).Value
+			obj := vm.pop()
+			getter, ok := obj.(object.MemberGetter)
+			if !ok {
+				err = object.NewError("type error: %s has no member %s", obj.Type(), name)
+				break
+			}
+			val := getter.GetMember(name)
+			if e, ok := val.(*object.Error); ok {
+				err = e
+				break
+			}
+			vm.push(val)
+		case code.OpSetMember:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			name := vm.constants[idx].(*object.String).Value
+			val := vm.pop()
+			obj := vm.pop()
+			setter, ok := obj.(object.MemberSetter)
+			if !ok {
+				err = object.NewError("type error: cannot assign member %s of %s", name, obj.Type())
+				break
+			}
+			if e, ok := setter.SetMember(name, val).(*object.Error); ok {
+				err = e
+			}
+
+		case code.OpThrow:
+			err = throw(vm.pop())
+		case code.OpTry:
+			addr := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, handler{frame: len(vm.frames) - 1, sp: vm.sp, addr: addr})
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			name := "unknown"
+			if def != nil {
+				name = def.Name
+			}
+			err = object.NewError("unsupported instruction %s at %d", name, ip)
+		}
+
+		if err != nil {
+			if result := vm.raise(err); result != nil {
+				return result
+			}
+		}
+	}
+}
+
+// 记录调用栈，跳转到最近的异常处理地址并将错误压栈；
+// 没有处理地址或错误不可捕获时返回该错误
+func (vm *VM) raise(err *object.Error) object.Object {
+	if err.Stack == nil {
+		err.Stack = vm.traceback()
+	}
+	if !err.Catchable() || len(vm.handlers) == 0 {
+		return err
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.frames = vm.frames[:h.frame+1]
+	vm.sp = h.sp
+	vm.push(&object.Exception{Error: err})
+	vm.frames[h.frame].ip = h.addr
+	return nil
+}
+
+func (vm *VM) traceback() []object.Frame {
+	frames := make([]object.Frame, len(vm.frames))
+	for i := range vm.frames {
+		f := &vm.frames[i]
+		pos := f.cl.Fn.Lines.Lookup(f.ip - 1)
+		frames[i] = object.Frame{Function: functionName(f.cl.Fn), Line: pos.Line, Column: pos.Column}
+	}
+	return frames
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// throw 捕获到的错误时原样抛出，字符串作为错误信息，其他值使用其 Inspect 结果
+func throw(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.Exception:
+		return val.Error
+	case *object.String:
+		return object.NewErrorKind(object.THROWN_ERROR, "%s", val.Value)
+	default:
+		return object.NewErrorKind(object.THROWN_ERROR, "%s", val.Inspect())
+	}
+}
+
+// 每执行一条指令调用一次，每256步检查一次ctx
+func (vm *VM) step() *object.Error {
+	vm.steps++
+	if vm.Limits.MaxSteps > 0 && vm.steps > vm.Limits.MaxSteps {
+		return object.NewErrorKind(object.STEP_LIMIT_ERROR, "step limit exceeded: %d", vm.Limits.MaxSteps)
+	}
+	if vm.steps&0xff == 0 && vm.ctx != nil {
+		if err := vm.ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return object.NewErrorKind(object.TIMEOUT_ERROR, "execution timed out")
+			}
+			return object.NewErrorKind(object.CANCELED_ERROR, "execution canceled")
+		}
+	}
+	return nil
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) println(args []object.Object) {
+	var out strings.Builder
+	for _, arg := range args {
+		out.WriteString(arg.Inspect())
+	}
+	out.WriteString("\n")
+	_, _ = io.WriteString(vm.Stdout, out.String())
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL:
+		return false
+	case TRUE:
+		return true
+	case FALSE:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,302 @@
+package vm
+
+import (
+	"TLanguage/compiler"
+	"TLanguage/evaluator"
+	"TLanguage/lexer"
+	"TLanguage/object"
+	"TLanguage/parser"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// evaluator 测试中的输入，虚拟机的结果必须与解释器一致
+var evaluatorInputs = []string{
+	"5", "10", "-5", "-10", "5 + 5 + 5 + 5 - 10", "2 * 2 * 2 * 2 * 2", "-50 + 100 + -50",
+	"5 * 2 + 10", "50 / 2 * 10", "(5 + 2) * 10", "5 * (2 * 1)",
+
+	"true", "false", "1 < 2", "1 > 2", "1 < 1", "1 > 1", "1 == 1", "1 != 1", "1 != 2",
+	"true == true", "true == false", "true != true", "false != true", "false == false",
+	"(1 < 2) == true", "(1 > 2) == false",
+
+	"!true", "!false", "!5", "!!true", "!!5",
+
+	"if (true) { 10 }", "if (false) { 10 }", "if (1) { 10 }", "if (1 < 2) { 10 }", "if (1 > 2) { 10 }",
+	"if (1 > 2) { 10 } else { 20 }",
+	"let isEmpty = fn(){return false;}; if( isEmpty() ){ 10 } else { 20 };",
+	"let isEmpty = fn(){ false; }; if( isEmpty() ){ 10 } else { 20 };",
+	"let isEmpty = fn(){ false; }; if( isEmpty() ){ 10 } else if ( isEmpty() ) { 20 } else { 30 };",
+	"let isEmpty = fn(){ false; }; if( isEmpty() ){ 10 } else if ( isEmpty() ) { 20 };",
+
+	"return 10;", "return 10;9;", "return 2 * 5; 9;", "9; return 2 * 5; 11;",
+	"9; return if ( true ) { 2 * 5 } else { 11 }; 12;", "return false;",
+
+	"5 + true", "-true", "foobar", `"Hello" - "World"`, "10 / (5 - 5)",
+
+	"let a = 5; a;", "let a = 5 * 5; a;", "let a = 5;let b = a; b", "let a = 5; let b = a; let c = a + b + 5; c",
+
+	"fn(x){x+2;};",
+
+	"let identity = fn(x){ x; }; identity(5);", "let identity = fn(x){ return x; }; identity(5);",
+	"let double = fn(x){ 2 * x; }; double(5);", "let add = fn(x, y){ x + y; }; add(5, 5);",
+	"let add = fn(x, y){ x + y; }; add(5, add(5, 5));", "let isEmpty = fn(){ return true }; isEmpty();",
+
+	"let newAdder = fn(x) { fn(y){ x + y }; }; let addTwo = newAdder(2); addTwo(2);",
+
+	`"Hello World!"`, `"Hello" + " " + "World!"`,
+
+	"let f = fn(x){ if (x > 1) { return 1; } return 2; }; f(5);",
+	"let f = fn(x){ if (x > 1) { return 1; } return 2; }; f(0);",
+	"let f = fn(){ let i = 0; while (true) { if (i == 3) { return i; } i = i + 1; } }; f();",
+	"return 10; 9;",
+
+	"let sum = fn(n, acc){ if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(1000000, 0);",
+	"let sum = fn(n, acc){ if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000000, 0);",
+	`let even = fn(n){ if (n == 0) { return 1; } odd(n - 1) };
+	 let odd = fn(n){ if (n == 0) { return 0; } even(n - 1) };
+	 even(1000001);`,
+
+	"let fab = fn(x){ return fab(x-2) + fab(x-1); }; fab(10);",
+
+	`try { throw "boom"; } catch (e) { e.message }`,
+	`try { throw "boom"; } catch (e) { e.kind }`,
+	`try { 1 + true } catch (e) { e.message }`,
+	`try { missing } catch (e) { e.kind }`,
+	`try { 10 } catch (e) { 20 }`,
+	`let f = fn(){ throw "inner"; }; try { f(); } catch (e) { e.stack }`,
+	`let r = 0; try { throw 1; } catch (e) { r = r + 1; } finally { r = r + 10; }; r`,
+	`let r = 0; let f = fn(){ try { return 1; } finally { r = 5; } }; f() + r`,
+	`let f = fn(){ try { return 1; } finally { return 2; } }; f()`,
+	`let f = fn(x){ if (x == 0) { throw "done"; } return f(x - 1); };
+	 try { f(3); } catch (e) { e.message }`,
+	`try { try { throw "a"; } catch (e) { throw e; } } catch (e2) { e2.message }`,
+	`throw "boom"; 5`,
+	`try { throw "a"; } finally { 1 }`,
+	`try { throw "a"; } catch (e) { throw "b"; }`,
+	`let a = fn(){ 0 }; let i = 0; while (i < 2) { let j = i; if (i == 0) { a = fn(){ j }; } i = i + 1; } a()`,
+	`let fs = 0; let i = 0; while (i < 3) { let k = i * 10; let g = fn(){ k }; if (i == 1) { fs = g; } i = i + 1; } fs()`,
+}
+
+func TestMatchesEvaluator(t *testing.T) {
+	for _, input := range evaluatorInputs {
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		expected := evaluator.New(io.Discard, io.Discard).Eval(program, object.NewEnvironment())
+		got := testRun(t, input, evaluator.Limits{})
+		testSameResult(t, input, expected, got)
+	}
+}
+
+func testSameResult(t *testing.T, input string, expected, got object.Object) {
+	t.Helper()
+	if expected == nil {
+		expected = NULL
+	}
+	if expErr, ok := expected.(*object.Error); ok {
+		gotErr, ok := got.(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected error %s, got %T(%s)", input, expErr.Inspect(), got, got.Inspect())
+			return
+		}
+		if gotErr.Kind != expErr.Kind || gotErr.Message != expErr.Message {
+			t.Errorf("%q: expected %s: %s, got %s: %s", input, expErr.Kind, expErr.Message, gotErr.Kind, gotErr.Message)
+		}
+		return
+	}
+	if got.Type() != expected.Type() || got.Inspect() != expected.Inspect() {
+		t.Errorf("%q: expected %s(%s), got %s(%s)", input, expected.Type(), expected.Inspect(), got.Type(), got.Inspect())
+	}
+}
+
+func testRun(t *testing.T, input string, limits evaluator.Limits) object.Object {
+	t.Helper()
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	machine := New(comp.Bytecode(), io.Discard, io.Discard)
+	machine.Limits = limits
+	return machine.Run()
+}
+
+func TestClosuresShareVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let counter = fn(){ let c = 0; fn(){ c = c + 1; c } };
+		  let next = counter(); next(); next(); next();`, 3},
+		{`let f = fn(){ let x = 1; let get = fn(){ x }; x = 5; get() }; f();`, 5},
+		{`let f = fn(n){ let loop = fn(i, acc){ if (i > n) { return acc; } loop(i + 1, acc + i) }; loop(1, 0) }; f(100);`, 5050},
+		{`let outer = fn(a){ fn(b){ fn(c){ a + b + c } } }; outer(1)(2)(3);`, 6},
+		{`let x = 1; let inc = fn(){ x = x + 1; }; inc(); inc(); x`, 3},
+		{`let f = fn(){ let fs = 0; let i = 0; let first = 0;
+		    while (i < 3) { let j = i; if (i == 0) { first = fn(){ j }; } i = i + 1; }
+		    first() }; f();`, 0},
+	}
+	for _, tt := range tests {
+		result := testRun(t, tt.input, evaluator.Limits{})
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != tt.expected {
+			t.Errorf("%q: expected %d, got %T(%+v)", tt.input, tt.expected, result, result)
+		}
+	}
+}
+
+func TestPrintln(t *testing.T) {
+	input := `let a = 1;
+while (a < 4) { println("a=", a); a = a + 1; }
+println(fn(x, y){ x + y }, " ", true, " ", if (false) { 1 });`
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	var expected, got bytes.Buffer
+	evaluator.New(&expected, io.Discard).Eval(program, object.NewEnvironment())
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	New(comp.Bytecode(), &got, io.Discard).Run()
+	if got.String() != expected.String() {
+		t.Errorf("output mismatch.\nexpected:\n%s\ngot:\n%s", expected.String(), got.String())
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input        string
+		limits       evaluator.Limits
+		expectedKind string
+	}{
+		{"while(true){}", evaluator.Limits{MaxSteps: 10000}, object.STEP_LIMIT_ERROR},
+		{"while(true){}", evaluator.Limits{Timeout: 20 * time.Millisecond}, object.TIMEOUT_ERROR},
+		{"let fab = fn(x){ return fab(x-2) + fab(x-1); }; fab(10);", evaluator.Limits{MaxDepth: 100}, object.DEPTH_LIMIT_ERROR},
+		{`let s = "ab"; while(true){ s = s + s; }`, evaluator.Limits{MaxAllocSize: 1 << 20}, object.ALLOC_LIMIT_ERROR},
+		{"try { while(true){} } catch (e) { 1 }", evaluator.Limits{MaxSteps: 1000}, object.STEP_LIMIT_ERROR},
+	}
+	for _, tt := range tests {
+		result := testRun(t, tt.input, tt.limits)
+		errObj, ok := result.(*object.Error)
+		if !ok || errObj.Kind != tt.expectedKind {
+			t.Errorf("%q: expected %s error. Got %T(%+v)", tt.input, tt.expectedKind, result, result)
+		}
+	}
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer("while(true){}")).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	result := New(comp.Bytecode(), io.Discard, io.Discard).RunContext(ctx)
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Kind != object.CANCELED_ERROR {
+		t.Fatalf("expected %s error. Got %T(%+v)", object.CANCELED_ERROR, result, result)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(a) {
+	a + missing;
+};
+let outer = fn() {
+	let r = inner(1);
+	r;
+};
+outer();`
+	result := testRun(t, input, evaluator.Limits{})
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. Got %T(%+v)", result, result)
+	}
+	expected := []object.Frame{
+		{Function: "<main>", Line: 8, Column: 1},
+		{Function: "outer", Line: 5, Column: 10},
+		{Function: "inner", Line: 2, Column: 6},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. expected %d, got %d: %+v", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("stack[%d] wrong. expected %+v, got %+v", i, frame, errObj.Stack[i])
+		}
+	}
+}
+
+// 尾调用复用调用者的栈帧，调用位置仍是最初的调用处，解释器与虚拟机的调用栈相同
+func TestTailCallErrorStack(t *testing.T) {
+	input := `let t = fn(x) {
+	if (x > 0) {
+		t(x - 1)
+	} else {
+		1 + true
+	}
+};
+t(2);`
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	expected, ok := evaluator.New(io.Discard, io.Discard).Eval(program, object.NewEnvironment()).(*object.Error)
+	if !ok {
+		t.Fatalf("evaluator: no error object returned")
+	}
+	got, ok := testRun(t, input, evaluator.Limits{}).(*object.Error)
+	if !ok {
+		t.Fatalf("vm: no error object returned")
+	}
+	main := object.Frame{Function: "<main>", Line: 8, Column: 1}
+	if len(expected.Stack) != 2 || expected.Stack[0] != main || expected.Stack[1].Function != "t" {
+		t.Errorf("wrong evaluator stack: %+v", expected.Stack)
+	}
+	if expected.Traceback("t.tl") != got.Traceback("t.tl") {
+		t.Errorf("tracebacks differ.\nevaluator:\n%s\nvm:\n%s", expected.Traceback("t.tl"), got.Traceback("t.tl"))
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	symbols := compiler.NewSymbolTable()
+	n := symbols.Define("n")
+	globals := make([]object.Object, GlobalsSize)
+	globals[n.Index] = &object.Integer{Value: 41}
+	var constants []object.Object
+	for _, line := range []string{"let m = n + 1;", "m"} {
+		program := parser.NewParser(lexer.NewLexer(line)).ParseProgram()
+		comp := compiler.NewWithState(symbols, constants)
+		if err := comp.Compile(program); err != nil {
+			t.Fatal(err)
+		}
+		constants = comp.Bytecode().Constants
+		result := NewWithGlobalsStore(comp.Bytecode(), globals, io.Discard, io.Discard).Run()
+		if line == "m" {
+			integer, ok := result.(*object.Integer)
+			if !ok || integer.Value != 42 {
+				t.Errorf("expected 42, got %T(%+v)", result, result)
+			}
+		}
+	}
+}
+
+func BenchmarkWhileLoop(b *testing.B) {
+	input := `let i = 0; let sum = 0; while (i < 10000) { sum = sum + i; i = i + 1; } sum`
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	b.Run("evaluator", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evaluator.New(io.Discard, io.Discard).Eval(program, object.NewEnvironment())
+		}
+	})
+	b.Run("vm", func(b *testing.B) {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			New(comp.Bytecode(), io.Discard, io.Discard).Run()
+		}
+	})
+}